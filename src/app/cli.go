@@ -0,0 +1,212 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+const (
+	ExitOk      = 0
+	ExitError   = 1
+	ExitUsage   = 2
+	ExitChanges = 3
+)
+
+// setting maps a command line flag to the environment variable it overrides
+type setting struct {
+	flag    string
+	env     string
+	usage   string
+	boolean bool
+}
+
+var settings = []setting{
+	{flag: "vault-address", env: VaultAddress, usage: "Hashicorp Vault address"},
+	{flag: "vault-auth-method", env: VaultAuthMethod, usage: "Hashicorp Vault authentication method (token, approle, github, jwt, kubernetes, cert, userpass, ldap)"},
+	{flag: "vault-auth-mount", env: VaultAuthMount, usage: "Hashicorp Vault auth method mount path, defaults to the auth method name"},
+	{flag: "vault-auth-role", env: VaultAuthRole, usage: "Hashicorp Vault auth role (jwt, kubernetes), or the certificate role name (cert)"},
+	{flag: "vault-jwt-audience", env: VaultJwtAudience, usage: "Audience requested for the Github Actions OIDC token (jwt)"},
+	{flag: "oidc-request-url", env: OidcRequestUrl, usage: "Github Actions OIDC token request URL (jwt)"},
+	{flag: "oidc-request-token", env: OidcRequestToken, usage: "Github Actions OIDC token request token (jwt)"},
+	{flag: "vault-kubernetes-token-path", env: VaultKubeTokenPath, usage: "Path of the service account token used by the kubernetes auth method"},
+	{flag: "vault-ca-cert", env: VaultCaCert, usage: "PEM encoded CA certificate, or the path of one, used to verify the Vault server"},
+	{flag: "vault-ca-path", env: VaultCaPath, usage: "Directory of PEM encoded CA certificates used to verify the Vault server"},
+	{flag: "vault-tls-client-cert", env: VaultClientCert, usage: "Path of the client certificate presented to Vault for mutual TLS"},
+	{flag: "vault-tls-client-key", env: VaultClientKey, usage: "Path of the private key of the Vault client certificate"},
+	{flag: "vault-tls-server-name", env: VaultTlsServerName, usage: "Server name used to verify the Vault certificate, when it differs from the address host"},
+	{flag: "vault-tls-skip-verify", env: VaultTlsSkipVerify, usage: "Skip the verification of the Vault server certificate, insecure", boolean: true},
+	{flag: "github-token", env: GithubToken, usage: "Github token"},
+	{flag: "vault-approle-id", env: VaultAppRoleId, usage: "Hashicorp Vault AppRole ID"},
+	{flag: "vault-approle-secret-id", env: VaultAppRoleSecretId, usage: "Hashicorp Vault AppRole Secret ID"},
+	{flag: "vault-approle-wrapped-secret-id", env: VaultAppRoleWrapped, usage: "Response wrapping token of the Hashicorp Vault AppRole Secret ID, unwrapped before logging in"},
+	{flag: "vault-username", env: VaultUsername, usage: "Hashicorp Vault username (userpass, ldap)"},
+	{flag: "vault-password", env: VaultPassword, usage: "Hashicorp Vault password (userpass, ldap), prompted for on a terminal when empty by the commands that log in"},
+	{flag: "vault-token", env: VaultToken, usage: "Hashicorp Vault token"},
+	{flag: "vault-namespace", env: VaultNamespace, usage: "Hashicorp Vault namespace"},
+	{flag: "vault-engine", env: VaultEngine, usage: "Hashicorp Vault engine (mount) name"},
+	{flag: "vault-secret-path", env: VaultSecretPath, usage: "Hashicorp Vault secret path, or a comma separated list of [engine:]path entries"},
+	{flag: "vault-kv-version", env: VaultKvVersion, usage: "Hashicorp Vault KV engine version (1 or 2), detected when empty"},
+	{flag: "vault-secret-version", env: VaultSecretVersion, usage: "Hashicorp Vault KV v2 secret version to apply"},
+	{flag: "vault-source-type", env: VaultSourceType, usage: "Vault source type (kv, pki, database, dynamic)"},
+	{flag: "vault-pki-common-name", env: VaultPkiCommonName, usage: "Common name of the certificate issued by the PKI engine (pki)"},
+	{flag: "vault-pki-alt-names", env: VaultPkiAltNames, usage: "Comma separated subject alternative names of the issued certificate (pki)"},
+	{flag: "vault-pki-ttl", env: VaultPkiTtl, usage: "Requested TTL of the issued certificate (pki)"},
+	{flag: "vault-pki-renew-before", env: VaultPkiRenewBefore, usage: "Re-issue when the applied certificate expires within this duration, defaults to a third of its lifetime (pki)"},
+	{flag: "vault-revoke-previous-lease", env: VaultRevokeLease, usage: "Revoke the lease recorded on the secret once new dynamic credentials are applied (database, dynamic)", boolean: true},
+	{flag: "vault-merge-strategy", env: VaultMergeStrategy, usage: "How duplicate keys of several secret paths are resolved (last-wins, first-wins, fail)"},
+	{flag: "vault-flatten-mode", env: VaultFlattenMode, usage: "Expand nested objects into dotted (dot) or underscored (underscore) keys instead of JSON values"},
+	{flag: "vault-secret-recursive", env: VaultSecretRecursive, usage: "Apply every secret below the secret path as its own object", boolean: true},
+	{flag: "kubeconfig", env: Kubeconfig, usage: "Kubernetes config file in a base64 encoded string"},
+	{flag: "kubernetes-namespace", env: Namespace, usage: "Kubernetes namespace"},
+	{flag: "load-as-configmap", env: ApplyAsConfigmap, usage: "Apply as configmap instead of secret", boolean: true},
+	{flag: "object-name-to-apply", env: ObjectNameToApply, usage: "Kubernetes object name to apply"},
+	{flag: "secret-type", env: SecretType, usage: "Kubernetes secret type (Opaque, kubernetes.io/tls, kubernetes.io/dockerconfigjson, kubernetes.io/basic-auth, kubernetes.io/ssh-auth)"},
+	{flag: "secret-key-mapping", env: SecretKeyMapping, usage: "Comma separated typeKey=vaultKey pairs naming the Vault keys of the secret type keys"},
+	{flag: "base64-key-suffix", env: Base64KeySuffix, usage: "Suffix of the Vault keys holding base64 encoded binary values, like _b64, decoded into the object under the key without it"},
+	{flag: "base64-keys", env: Base64Keys, usage: "Comma separated Vault keys holding base64 encoded binary values, decoded into the object under the same key"},
+	{flag: "key-include", env: KeyInclude, usage: "Comma separated globs, or /regular expressions/, of the Vault keys to apply, all keys when empty"},
+	{flag: "key-exclude", env: KeyExclude, usage: "Comma separated globs, or /regular expressions/, of the Vault keys to leave out"},
+	{flag: "key-rename", env: KeyRename, usage: "Comma separated vaultKey:objectKey pairs naming object keys, exempt from the case, prefix and suffix rules"},
+	{flag: "key-prefix", env: KeyPrefix, usage: "Prefix added to every object key"},
+	{flag: "key-suffix", env: KeySuffix, usage: "Suffix added to every object key"},
+	{flag: "key-case", env: KeyCase, usage: "Convert the object keys to upper or lower case"},
+	{flag: "template", env: Template, usage: "Go template rendered with the Vault data into the key set by --template-key, with toJson, toYaml, b64enc, indent and default helpers"},
+	{flag: "template-key", env: TemplateKey, usage: "Object key holding the rendered inline template, like application.properties"},
+	{flag: "template-files", env: TemplateFiles, usage: "Comma separated template files, each rendered into the key named after the file without .tmpl or .tpl, or given as key=path"},
+	{flag: "image-pull-secret", env: ImagePullSecret, usage: "Build a dockerconfigjson secret with one registry per secret path", boolean: true},
+	{flag: "registry-key-mapping", env: RegistryKeyMapping, usage: "Comma separated key=vaultKey pairs naming the Vault keys of the registry server, username, password and email"},
+	{flag: "object-name-template", env: ObjectNameTemplate, usage: "Go template naming the objects of a recursive sync"},
+	{flag: "sync-manifest", env: SyncManifest, usage: "Path of a YAML or JSON manifest listing several Vault to Kubernetes mappings"},
+	{flag: "prune", env: Prune, usage: "Remove the object keys that are no longer in Vault", boolean: true},
+	{flag: "prune-force", env: PruneForce, usage: "Also prune keys owned by other field managers", boolean: true},
+	{flag: "watch-interval", env: WatchInterval, usage: "Interval between syncs of the watch command"},
+	{flag: "watch-jitter", env: WatchJitter, usage: "Maximum random delay added to every watch interval, defaults to a tenth of the interval"},
+	{flag: "dry-run", env: DryRun, usage: "Print the planned changes instead of applying them, exiting with 3 when changes are pending", boolean: true},
+}
+
+var subcommands = []struct {
+	name  string
+	usage string
+}{
+	{name: "sync", usage: "Apply the Vault secrets to Kubernetes (default)"},
+	{name: "diff", usage: "Print the changes sync would make without applying them"},
+	{name: "watch", usage: "Keep syncing on an interval, applying only objects changed in Vault or edited in the cluster, until interrupted"},
+	{name: "validate", usage: "Check the configuration without contacting Vault or Kubernetes"},
+	{name: "version", usage: "Print the version"},
+	{name: "help", usage: "Print this help"},
+}
+
+// envValue sets its environment variable when the flag is given, so flags take precedence over the environment
+type envValue struct {
+	env     string
+	boolean bool
+}
+
+func (value envValue) String() string {
+	return ""
+}
+
+func (value envValue) Set(s string) error {
+	return os.Setenv(value.env, s)
+}
+
+func (value envValue) IsBoolFlag() bool {
+	return value.boolean
+}
+
+// Run executes the command line and returns the process exit code
+func Run(args []string, version string, stdout io.Writer, stderr io.Writer) int {
+	subcommand := "sync"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		subcommand = args[0]
+		args = args[1:]
+	}
+
+	flags := flag.NewFlagSet("k8s-from-secrets-vault", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		printUsage(stderr)
+	}
+	for _, s := range settings {
+		flags.Var(envValue{env: s.env, boolean: s.boolean}, s.flag, s.usage)
+	}
+
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOk
+	}
+	if err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected argument %s\n", flags.Arg(0))
+		printUsage(stderr)
+		return ExitUsage
+	}
+
+	switch subcommand {
+	case "help":
+		printUsage(stdout)
+		return ExitOk
+	case "version":
+		fmt.Fprintln(stdout, version)
+		return ExitOk
+	case "sync", "diff", "validate", "watch":
+	default:
+		fmt.Fprintf(stderr, "unknown command %s\n", subcommand)
+		printUsage(stderr)
+		return ExitUsage
+	}
+
+	command, err := SetupCommand()
+	if err != nil {
+		return ExitError
+	}
+
+	switch subcommand {
+	case "validate":
+		fmt.Fprintln(stdout, "Configuration is valid")
+	case "diff":
+		err = command.Diff(stdout)
+	case "watch":
+		if command.DryRun {
+			fmt.Fprintln(stderr, "dry run can not be used with watch, use diff instead")
+			return ExitUsage
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err = command.Watch(ctx)
+	default:
+		err = command.Execute()
+	}
+	if errors.Is(err, ErrChangesPending) {
+		return ExitChanges
+	}
+	if err != nil {
+		return ExitError
+	}
+	return ExitOk
+}
+
+func printUsage(out io.Writer) {
+	fmt.Fprintf(out, "Usage: k8s-from-secrets-vault [command] [flags]\n\nCommands:\n")
+	for _, subcommand := range subcommands {
+		fmt.Fprintf(out, "  %-10s %s\n", subcommand.name, subcommand.usage)
+	}
+
+	fmt.Fprintf(out, "\nFlags (each flag overrides its environment variable):\n")
+	for _, s := range settings {
+		name := "--" + s.flag
+		if !s.boolean {
+			name += " value"
+		}
+		fmt.Fprintf(out, "  %s\n        %s [%s]\n", name, s.usage, s.env)
+	}
+}
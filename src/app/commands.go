@@ -1,73 +1,286 @@
 package app
 
 import (
-	"context"
-	"fmt"
+	"errors"
 	"github.com/sirupsen/logrus"
+	"io"
 	kubernetes "k8s-from-secrets-vault/kubernetes"
 	vault "k8s-from-secrets-vault/vault"
 	"os"
+	"strconv"
+	"time"
 )
 
 const (
 	VaultAddress         = "VAULT_ADDRESS"
 	VaultAuthMethod      = "VAULT_AUTH_METHOD"
+	VaultAuthMount       = "VAULT_AUTH_MOUNT"
+	VaultAuthRole        = "VAULT_AUTH_ROLE"
+	VaultJwtAudience     = "VAULT_JWT_AUDIENCE"
+	OidcRequestUrl       = "ACTIONS_ID_TOKEN_REQUEST_URL"
+	OidcRequestToken     = "ACTIONS_ID_TOKEN_REQUEST_TOKEN"
+	VaultKubeTokenPath   = "VAULT_KUBERNETES_TOKEN_PATH"
+	VaultCaCert          = "VAULT_CA_CERT"
+	VaultCaPath          = "VAULT_CA_PATH"
+	VaultClientCert      = "VAULT_TLS_CLIENT_CERT"
+	VaultClientKey       = "VAULT_TLS_CLIENT_KEY"
+	VaultTlsServerName   = "VAULT_TLS_SERVER_NAME"
+	VaultTlsSkipVerify   = "VAULT_TLS_SKIP_VERIFY"
 	GithubToken          = "GITHUB_TOKEN"
 	VaultAppRoleId       = "VAULT_APPROLE_ID"
 	VaultAppRoleSecretId = "VAULT_APPROLE_SECRET_ID"
+	VaultAppRoleWrapped  = "VAULT_APPROLE_WRAPPED_SECRET_ID"
+	VaultUsername        = "VAULT_USERNAME"
+	VaultPassword        = "VAULT_PASSWORD"
 	VaultToken           = "VAULT_TOKEN"
 	VaultNamespace       = "VAULT_NAMESPACE"
 	VaultEngine          = "VAULT_ENGINE"
 	VaultSecretPath      = "VAULT_SECRET_PATH"
+	VaultKvVersion       = "VAULT_KV_VERSION"
+	VaultSecretVersion   = "VAULT_SECRET_VERSION"
+	VaultMergeStrategy   = "VAULT_MERGE_STRATEGY"
+	VaultFlattenMode     = "VAULT_FLATTEN_MODE"
 	Kubeconfig           = "KUBECONFIG"
 	Namespace            = "KUBERNETES_NAMESPACE"
 	ApplyAsConfigmap     = "LOAD_AS_CONFIGMAP"
 	ObjectNameToApply    = "OBJECT_NAME_TO_APPLY"
+	VaultSecretRecursive = "VAULT_SECRET_RECURSIVE"
+	ObjectNameTemplate   = "OBJECT_NAME_TEMPLATE"
+	SyncManifest         = "SYNC_MANIFEST"
+	DryRun               = "DRY_RUN"
+	Prune                = "PRUNE"
+	PruneForce           = "PRUNE_FORCE"
+	SecretType           = "SECRET_TYPE"
+	SecretKeyMapping     = "SECRET_KEY_MAPPING"
+	ImagePullSecret      = "IMAGE_PULL_SECRET"
+	RegistryKeyMapping   = "REGISTRY_KEY_MAPPING"
+	Base64KeySuffix      = "BASE64_KEY_SUFFIX"
+	Base64Keys           = "BASE64_KEYS"
+	KeyInclude           = "KEY_INCLUDE"
+	KeyExclude           = "KEY_EXCLUDE"
+	KeyRename            = "KEY_RENAME"
+	KeyPrefix            = "KEY_PREFIX"
+	KeySuffix            = "KEY_SUFFIX"
+	KeyCase              = "KEY_CASE"
+	Template             = "TEMPLATE"
+	TemplateKey          = "TEMPLATE_KEY"
+	TemplateFiles        = "TEMPLATE_FILES"
+	VaultSourceType      = "VAULT_SOURCE_TYPE"
+	VaultPkiCommonName   = "VAULT_PKI_COMMON_NAME"
+	VaultPkiAltNames     = "VAULT_PKI_ALT_NAMES"
+	VaultPkiTtl          = "VAULT_PKI_TTL"
+	VaultPkiRenewBefore  = "VAULT_PKI_RENEW_BEFORE"
+	VaultRevokeLease     = "VAULT_REVOKE_PREVIOUS_LEASE"
+	WatchInterval        = "WATCH_INTERVAL"
+	WatchJitter          = "WATCH_JITTER"
 )
 
+const (
+	SourceTypeKv       = "kv"
+	SourceTypePki      = "pki"
+	SourceTypeDatabase = "database"
+	SourceTypeDynamic  = "dynamic"
+)
+
+// ErrChangesPending is returned by a dry run when applying would change at least one object
+var ErrChangesPending = NewError("Changes are pending")
+
 type Command struct {
 	Address         string
 	AuthToken       string
 	AuthMethod      string
+	AuthMount       string
+	AuthRole        string
 	AppRoleId       string
 	AppRoleSecretId string
+	AppRoleWrapped  string
+	Username        string
+	Password        string
 	GithubToken     string
 	VaultNamespace  string
 	EngineName      string
 	SecretPath      string
+	KvVersion       string
+	SecretVersion   int
+	MergeStrategy   string
+	FlattenMode     string
+	SourceType      string
+
+	PkiCommonName  string
+	PkiAltNames    string
+	PkiTtl         string
+	PkiRenewBefore time.Duration
+
+	RevokePreviousLease bool
+
+	JwtAudience      string
+	OidcRequestUrl   string
+	OidcRequestToken string
+
+	KubernetesTokenPath string
+
+	CaCert        string
+	CaPath        string
+	ClientCert    string
+	ClientKey     string
+	TlsServerName string
+	TlsSkipVerify bool
 
 	Base64Kubeconfig string
 	Namespace        string
 
 	LoadAsConfigMap   bool
 	ObjectNameToApply string
+	SecretType        string
+	SecretKeyMapping  string
+	Base64KeySuffix   string
+	Base64Keys        string
+
+	KeyInclude string
+	KeyExclude string
+	KeyRename  string
+	KeyPrefix  string
+	KeySuffix  string
+	KeyCase    string
+
+	Template      string
+	TemplateKey   string
+	TemplateFiles string
+
+	ImagePullSecret    bool
+	RegistryKeyMapping string
+
+	Recursive          bool
+	ObjectNameTemplate string
+
+	ManifestPath string
+
+	DryRun     bool
+	Prune      bool
+	ForcePrune bool
+
+	WatchInterval time.Duration
+	WatchJitter   time.Duration
 
 	kubernetesClient kubernetes.KubernetesClient
+	vaultClient      *vault.Client
+	planning         bool
+	watching         bool
 }
 
 func SetupCommand() (*Command, error) {
 	log := setupLogger()
 
 	var command = Command{
-		Address:           os.Getenv(VaultAddress),
-		AuthToken:         os.Getenv(VaultToken),
-		AuthMethod:        os.Getenv(VaultAuthMethod),
-		AppRoleId:         os.Getenv(VaultAppRoleId),
-		AppRoleSecretId:   os.Getenv(VaultAppRoleSecretId),
-		GithubToken:       os.Getenv(GithubToken),
-		VaultNamespace:    os.Getenv(VaultNamespace),
-		EngineName:        os.Getenv(VaultEngine),
-		SecretPath:        os.Getenv(VaultSecretPath),
-		Base64Kubeconfig:  os.Getenv(Kubeconfig),
-		Namespace:         os.Getenv(Namespace),
-		ObjectNameToApply: os.Getenv(ObjectNameToApply),
-		LoadAsConfigMap:   os.Getenv(ApplyAsConfigmap) == "true",
+		Address:             os.Getenv(VaultAddress),
+		AuthToken:           os.Getenv(VaultToken),
+		AuthMethod:          os.Getenv(VaultAuthMethod),
+		AuthMount:           os.Getenv(VaultAuthMount),
+		AuthRole:            os.Getenv(VaultAuthRole),
+		AppRoleId:           os.Getenv(VaultAppRoleId),
+		AppRoleSecretId:     os.Getenv(VaultAppRoleSecretId),
+		AppRoleWrapped:      os.Getenv(VaultAppRoleWrapped),
+		Username:            os.Getenv(VaultUsername),
+		Password:            os.Getenv(VaultPassword),
+		GithubToken:         os.Getenv(GithubToken),
+		VaultNamespace:      os.Getenv(VaultNamespace),
+		EngineName:          os.Getenv(VaultEngine),
+		SecretPath:          os.Getenv(VaultSecretPath),
+		KvVersion:           os.Getenv(VaultKvVersion),
+		MergeStrategy:       os.Getenv(VaultMergeStrategy),
+		FlattenMode:         os.Getenv(VaultFlattenMode),
+		SourceType:          os.Getenv(VaultSourceType),
+		PkiCommonName:       os.Getenv(VaultPkiCommonName),
+		PkiAltNames:         os.Getenv(VaultPkiAltNames),
+		PkiTtl:              os.Getenv(VaultPkiTtl),
+		RevokePreviousLease: os.Getenv(VaultRevokeLease) == "true",
+		JwtAudience:         os.Getenv(VaultJwtAudience),
+		OidcRequestUrl:      os.Getenv(OidcRequestUrl),
+		OidcRequestToken:    os.Getenv(OidcRequestToken),
+		KubernetesTokenPath: os.Getenv(VaultKubeTokenPath),
+		CaCert:              os.Getenv(VaultCaCert),
+		CaPath:              os.Getenv(VaultCaPath),
+		ClientCert:          os.Getenv(VaultClientCert),
+		ClientKey:           os.Getenv(VaultClientKey),
+		TlsServerName:       os.Getenv(VaultTlsServerName),
+		TlsSkipVerify:       os.Getenv(VaultTlsSkipVerify) == "true",
+		Base64Kubeconfig:    os.Getenv(Kubeconfig),
+		Namespace:           os.Getenv(Namespace),
+		ObjectNameToApply:   os.Getenv(ObjectNameToApply),
+		LoadAsConfigMap:     os.Getenv(ApplyAsConfigmap) == "true",
+		SecretType:          os.Getenv(SecretType),
+		SecretKeyMapping:    os.Getenv(SecretKeyMapping),
+		ImagePullSecret:     os.Getenv(ImagePullSecret) == "true",
+		RegistryKeyMapping:  os.Getenv(RegistryKeyMapping),
+		Base64KeySuffix:     os.Getenv(Base64KeySuffix),
+		Base64Keys:          os.Getenv(Base64Keys),
+		KeyInclude:          os.Getenv(KeyInclude),
+		KeyExclude:          os.Getenv(KeyExclude),
+		KeyRename:           os.Getenv(KeyRename),
+		KeyPrefix:           os.Getenv(KeyPrefix),
+		KeySuffix:           os.Getenv(KeySuffix),
+		KeyCase:             os.Getenv(KeyCase),
+		Template:            os.Getenv(Template),
+		TemplateKey:         os.Getenv(TemplateKey),
+		TemplateFiles:       os.Getenv(TemplateFiles),
+		Recursive:           os.Getenv(VaultSecretRecursive) == "true",
+		ObjectNameTemplate:  os.Getenv(ObjectNameTemplate),
+		ManifestPath:        os.Getenv(SyncManifest),
+		DryRun:              os.Getenv(DryRun) == "true",
+		Prune:               os.Getenv(Prune) == "true",
+		ForcePrune:          os.Getenv(PruneForce) == "true",
 	}
 
 	if command.AuthMethod == "" {
 		command.AuthMethod = "token"
 	}
-
+	if os.Getenv(VaultSecretVersion) != "" {
+		secretVersion, err := strconv.Atoi(os.Getenv(VaultSecretVersion))
+		if err != nil || secretVersion < 1 {
+			err = NewError("Vault secret version must be a positive number")
+			log.WithError(err).Error("Failed to validate command")
+			return nil, err
+		}
+		command.SecretVersion = secretVersion
+	}
+	if os.Getenv(VaultPkiRenewBefore) != "" {
+		renewBefore, err := time.ParseDuration(os.Getenv(VaultPkiRenewBefore))
+		if err != nil || renewBefore < 0 {
+			err = NewError("Vault PKI renew before must be a positive duration")
+			log.WithError(err).Error("Failed to validate command")
+			return nil, err
+		}
+		command.PkiRenewBefore = renewBefore
+	}
+	command.WatchInterval = DefaultWatchInterval
+	if os.Getenv(WatchInterval) != "" {
+		interval, err := time.ParseDuration(os.Getenv(WatchInterval))
+		if err != nil || interval <= 0 {
+			err = NewError("Watch interval must be a positive duration")
+			log.WithError(err).Error("Failed to validate command")
+			return nil, err
+		}
+		command.WatchInterval = interval
+	}
+	command.WatchJitter = command.WatchInterval / 10
+	if os.Getenv(WatchJitter) != "" {
+		jitter, err := time.ParseDuration(os.Getenv(WatchJitter))
+		if err != nil || jitter < 0 {
+			err = NewError("Watch jitter must be a positive duration")
+			log.WithError(err).Error("Failed to validate command")
+			return nil, err
+		}
+		command.WatchJitter = jitter
+	}
+	if command.SourceType == "" {
+		command.SourceType = SourceTypeKv
+	}
+	if command.ObjectNameTemplate == "" {
+		command.ObjectNameTemplate = DefaultObjectNameTemplate
+	}
+	if command.KubernetesTokenPath == "" {
+		command.KubernetesTokenPath = vault.DefaultKubernetesTokenPath
+	}
 	err := command.Validate()
 	if err != nil {
 		log.WithError(err).Error("Failed to validate command")
@@ -85,12 +298,60 @@ func SetupCommandWithKubernetesClient(args map[string]string, kubernetesClient k
 	_ = os.Setenv(VaultNamespace, args[VaultNamespace])
 	_ = os.Setenv(VaultEngine, args[VaultEngine])
 	_ = os.Setenv(VaultSecretPath, args[VaultSecretPath])
+	_ = os.Setenv(VaultKvVersion, args[VaultKvVersion])
+	_ = os.Setenv(VaultSecretVersion, args[VaultSecretVersion])
+	_ = os.Setenv(VaultMergeStrategy, args[VaultMergeStrategy])
+	_ = os.Setenv(VaultFlattenMode, args[VaultFlattenMode])
+	_ = os.Setenv(VaultSourceType, args[VaultSourceType])
+	_ = os.Setenv(VaultPkiCommonName, args[VaultPkiCommonName])
+	_ = os.Setenv(VaultPkiAltNames, args[VaultPkiAltNames])
+	_ = os.Setenv(VaultPkiTtl, args[VaultPkiTtl])
+	_ = os.Setenv(VaultPkiRenewBefore, args[VaultPkiRenewBefore])
+	_ = os.Setenv(VaultRevokeLease, args[VaultRevokeLease])
+	_ = os.Setenv(WatchInterval, args[WatchInterval])
+	_ = os.Setenv(WatchJitter, args[WatchJitter])
 	_ = os.Setenv(Kubeconfig, args[Kubeconfig])
 	_ = os.Setenv(Namespace, args[Namespace])
 	_ = os.Setenv(ApplyAsConfigmap, args[ApplyAsConfigmap])
 	_ = os.Setenv(ObjectNameToApply, args[ObjectNameToApply])
+	_ = os.Setenv(VaultSecretRecursive, args[VaultSecretRecursive])
+	_ = os.Setenv(ObjectNameTemplate, args[ObjectNameTemplate])
+	_ = os.Setenv(SyncManifest, args[SyncManifest])
+	_ = os.Setenv(DryRun, args[DryRun])
+	_ = os.Setenv(SecretType, args[SecretType])
+	_ = os.Setenv(SecretKeyMapping, args[SecretKeyMapping])
+	_ = os.Setenv(ImagePullSecret, args[ImagePullSecret])
+	_ = os.Setenv(RegistryKeyMapping, args[RegistryKeyMapping])
+	_ = os.Setenv(Base64KeySuffix, args[Base64KeySuffix])
+	_ = os.Setenv(Base64Keys, args[Base64Keys])
+	_ = os.Setenv(KeyInclude, args[KeyInclude])
+	_ = os.Setenv(KeyExclude, args[KeyExclude])
+	_ = os.Setenv(KeyRename, args[KeyRename])
+	_ = os.Setenv(KeyPrefix, args[KeyPrefix])
+	_ = os.Setenv(KeySuffix, args[KeySuffix])
+	_ = os.Setenv(KeyCase, args[KeyCase])
+	_ = os.Setenv(Template, args[Template])
+	_ = os.Setenv(TemplateKey, args[TemplateKey])
+	_ = os.Setenv(TemplateFiles, args[TemplateFiles])
+	_ = os.Setenv(Prune, args[Prune])
+	_ = os.Setenv(PruneForce, args[PruneForce])
 	_ = os.Setenv(VaultAppRoleId, args[VaultAppRoleId])
 	_ = os.Setenv(VaultAppRoleSecretId, args[VaultAppRoleSecretId])
+	_ = os.Setenv(VaultAppRoleWrapped, args[VaultAppRoleWrapped])
+	_ = os.Setenv(VaultUsername, args[VaultUsername])
+	_ = os.Setenv(VaultPassword, args[VaultPassword])
+	_ = os.Setenv(VaultAuthMount, args[VaultAuthMount])
+	_ = os.Setenv(VaultAuthRole, args[VaultAuthRole])
+	_ = os.Setenv(VaultJwtAudience, args[VaultJwtAudience])
+	_ = os.Setenv(OidcRequestUrl, args[OidcRequestUrl])
+	_ = os.Setenv(OidcRequestToken, args[OidcRequestToken])
+	_ = os.Setenv(VaultKubeTokenPath, args[VaultKubeTokenPath])
+	_ = os.Setenv(VaultCaCert, args[VaultCaCert])
+	_ = os.Setenv(VaultCaPath, args[VaultCaPath])
+	_ = os.Setenv(VaultClientCert, args[VaultClientCert])
+	_ = os.Setenv(VaultClientKey, args[VaultClientKey])
+	_ = os.Setenv(VaultTlsServerName, args[VaultTlsServerName])
+	_ = os.Setenv(VaultTlsSkipVerify, args[VaultTlsSkipVerify])
 
 	command, err := SetupCommand()
 	if err != nil {
@@ -102,23 +363,117 @@ func SetupCommandWithKubernetesClient(args map[string]string, kubernetesClient k
 }
 
 func (command Command) Execute() error {
-	if command.LoadAsConfigMap {
-		return command.loadAndApplyConfigMap()
+	if command.DryRun {
+		return command.dryRun(os.Stdout)
 	}
-	return command.loadAndApplySecrets()
+	return command.sync(applyObject)
+}
+
+// Diff writes the changes Execute would make to the Kubernetes objects without applying them
+func (command Command) Diff(out io.Writer) error {
+	_, err := command.plan(out)
+	return err
+}
+
+func (command Command) dryRun(out io.Writer) error {
+	pending, err := command.plan(out)
+	if err != nil {
+		return err
+	}
+	if pending {
+		setupLogger().Info("Dry run found pending changes")
+		return ErrChangesPending
+	}
+	return nil
+}
+
+func (command Command) plan(out io.Writer) (bool, error) {
+	command.planning = true
+	pending := false
+	err := command.sync(planObject(out, &pending))
+	return pending, err
+}
+
+func (command Command) sync(handler objectHandler) error {
+	if command.ManifestPath != "" {
+		return command.loadAndApplyManifest(handler)
+	}
+	if command.Recursive {
+		return command.loadAndApplyFolder(handler)
+	}
+	if command.ImagePullSecret {
+		return command.loadAndApplyImagePullSecret(handler)
+	}
+	if command.SourceType == SourceTypePki {
+		return command.loadAndApplyCertificate(handler)
+	}
+	if command.SourceType == SourceTypeDatabase || command.SourceType == SourceTypeDynamic {
+		return command.loadAndApplyLeasedSecret(handler)
+	}
+	return command.loadAndApplyObject(handler)
 }
 
 func (command Command) vaultParameters() vault.VaultConfig {
 	return vault.VaultConfig{
-		Address:     command.Address,
-		AuthMethod:  command.AuthMethod,
-		GithubToken: command.GithubToken,
-		AppRoleId:   command.AppRoleId,
-		SecretId:    command.AppRoleSecretId,
-		AuthToken:   command.AuthToken,
-		Namespace:   command.VaultNamespace,
-		EngineName:  command.EngineName,
-		SecretPath:  command.SecretPath,
+		Address:          command.Address,
+		AuthMethod:       command.AuthMethod,
+		AuthMount:        command.AuthMount,
+		AuthRole:         command.AuthRole,
+		GithubToken:      command.GithubToken,
+		AppRoleId:        command.AppRoleId,
+		SecretId:         command.AppRoleSecretId,
+		WrappedSecretId:  command.AppRoleWrapped,
+		Username:         command.Username,
+		Password:         command.Password,
+		AuthToken:        command.AuthToken,
+		Namespace:        command.VaultNamespace,
+		EngineName:       command.EngineName,
+		SecretPath:       command.SecretPath,
+		KvVersion:        command.KvVersion,
+		Version:          command.SecretVersion,
+		Sources:          command.secretSources(),
+		MergeStrategy:    command.MergeStrategy,
+		FlattenMode:      command.FlattenMode,
+		JwtAudience:      command.JwtAudience,
+		OidcRequestUrl:   command.OidcRequestUrl,
+		OidcRequestToken: command.OidcRequestToken,
+
+		KubernetesTokenPath: command.KubernetesTokenPath,
+
+		CaCert:        command.CaCert,
+		CaPath:        command.CaPath,
+		ClientCert:    command.ClientCert,
+		ClientKey:     command.ClientKey,
+		TlsServerName: command.TlsServerName,
+		TlsSkipVerify: command.TlsSkipVerify,
+	}
+}
+
+// secretSources returns the configured secret paths, merged in order when more than one is given
+func (command Command) secretSources() []vault.SecretSource {
+	return vault.ParseSecretSources(command.SecretPath, command.EngineName)
+}
+
+// newVaultClient returns the client kept by a long-running sync, or authenticates a new one, prompting for a missing password first
+func (command Command) newVaultClient(log *logrus.Logger) (*vault.Client, error) {
+	if command.vaultClient != nil {
+		return command.vaultClient, nil
+	}
+	if command.usesPasswordAuth() && command.Password == "" {
+		password, err := promptPassword(command.Username)
+		if err != nil {
+			log.WithError(err).Error("Failed to read the Vault password")
+			return nil, err
+		}
+		command.Password = password
+	}
+	return vault.NewClient(command.vaultParameters(), log)
+}
+
+// releaseVaultClient revokes the login token of a one-off client, a client kept by a long-running sync stays open
+func (command Command) releaseVaultClient(vaultClient *vault.Client) {
+	if command.vaultClient == nil {
+		vaultClient.Close()
 	}
 }
 
@@ -129,18 +484,10 @@ func (command Command) kubeParameters() kubernetes.KubernetesParameters {
 	}
 }
 
-func (command Command) loadAndApplySecrets() error {
-	log := setupLogger()
-
-	data, err := vault.LoadSecretData(command.vaultParameters(), log)
-
-	if err != nil {
-		return err
-	}
-
+func (command Command) createKubernetesClient(log *logrus.Logger) (kubernetes.KubernetesClient, error) {
 	kubernetesConfig, err := kubernetes.CreateConfig(command.kubeParameters(), log)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	kubernetesClient, err := kubernetes.CreateClient(kubernetesConfig, log)
@@ -148,87 +495,194 @@ func (command Command) loadAndApplySecrets() error {
 		kubernetesClient = command.kubernetesClient
 	}
 	if err != nil {
-		return err
-	}
-
-	err = kubernetesClient.ApplySecret(context.TODO(), command.ObjectNameToApply, data, log)
-	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return kubernetesClient, nil
 }
 
-func (command Command) loadAndApplyConfigMap() error {
+func (command Command) loadAndApplyObject(handler objectHandler) error {
 	log := setupLogger()
 
-	data, err := vault.LoadSecretData(command.vaultParameters(), log)
-
+	vaultClient, err := command.newVaultClient(log)
 	if err != nil {
 		return err
 	}
+	defer command.releaseVaultClient(vaultClient)
 
-	kubernetesConfig, err := kubernetes.CreateConfig(command.kubeParameters(), log)
+	secret, err := vaultClient.LoadSecret()
 	if err != nil {
 		return err
 	}
 
-	kubernetesClient, err := kubernetes.CreateClient(kubernetesConfig, log)
-	if command.kubernetesClient != nil {
-		kubernetesClient = command.kubernetesClient
-	}
+	options := command.applyOptions(secret)
+	data, err := command.objectData(secret.Data, &options)
 	if err != nil {
 		return err
 	}
 
-	err = kubernetesClient.ApplyConfigMap(context.TODO(), command.ObjectNameToApply, data, log)
+	kubernetesClient, err := command.createKubernetesClient(log)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return handler(kubernetesClient, targetObject{
+		kind:    command.objectKind(),
+		name:    command.ObjectNameToApply,
+		data:    data,
+		options: options,
+	}, log)
+}
+
+func (command Command) objectKind() string {
+	if command.LoadAsConfigMap {
+		return KindConfigMap
+	}
+	return KindSecret
+}
+
+func (command Command) applyOptions(secret vault.LoadedSecret) kubernetes.ApplyOptions {
+	options := kubernetes.ApplyOptions{
+		Annotations: map[string]string{},
+		Prune:       command.Prune,
+		ForcePrune:  command.ForcePrune,
+		SecretType:  command.SecretType,
+		SecretKeys:  parseKeyMapping(command.SecretKeyMapping),
+
+		Base64Suffix: command.Base64KeySuffix,
+		Base64Keys:   parseKeyList(command.Base64Keys),
+	}
+	if secret.Version > 0 {
+		options.Annotations[kubernetes.VaultSecretVersionAnnotation] = strconv.Itoa(secret.Version)
+	}
+	return options
 }
 
 func (command Command) Validate() error {
 	if command.Address == "" {
 		return NewError("Vault address is required")
 	}
-	if command.EngineName == "" {
+	if command.EngineName == "" && command.ManifestPath == "" {
 		return NewError("Vault engine name is required")
 	}
-	if command.SecretPath == "" {
+	if command.SecretPath == "" && command.ManifestPath == "" {
 		return NewError("Vault secret path is required")
 	}
+	if command.KvVersion != "" && command.KvVersion != vault.KvVersion1 && command.KvVersion != vault.KvVersion2 {
+		return NewError("Vault KV version must be 1 or 2")
+	}
+	if command.SecretVersion > 0 && command.KvVersion == vault.KvVersion1 {
+		return NewError("Vault secret version requires a KV version 2 engine")
+	}
+	if command.SecretVersion > 0 && len(command.secretSources()) > 1 {
+		return NewError("Vault secret version can only be pinned for a single secret path")
+	}
+	if command.MergeStrategy != "" && command.MergeStrategy != vault.MergeLastWins && command.MergeStrategy != vault.MergeFirstWins && command.MergeStrategy != vault.MergeFail {
+		return NewError("Vault merge strategy must be last-wins, first-wins or fail")
+	}
+	if command.FlattenMode != "" && command.FlattenMode != vault.FlattenDot && command.FlattenMode != vault.FlattenUnderscore {
+		return NewError("Vault flatten mode must be dot or underscore")
+	}
+	if !isSourceType(command.SourceType) {
+		return NewError("Vault source type must be kv, pki, database or dynamic")
+	}
 
-	if command.AuthMethod == "approle" && (command.AppRoleId == "" || command.AppRoleSecretId == "") {
+	if !vault.IsAuthMount(command.AuthMount) {
+		return NewError("Vault auth mount must be a path of letters, digits, dashes, underscores and dots, like teams/team-a/approle")
+	}
+	if command.AuthMethod == "approle" && (command.AppRoleId == "" || command.AppRoleSecretId == "" && command.AppRoleWrapped == "") {
 		return NewError("Vault RoleId and SecretId are required")
 	}
+	if command.AuthMethod == "approle" && command.AppRoleSecretId != "" && command.AppRoleWrapped != "" {
+		return NewError("Vault SecretId and wrapped SecretId cannot be used together")
+	}
 	if command.AuthMethod == "github" && command.GithubToken == "" {
 		return NewError("Github token is required")
 	}
+	if command.usesPasswordAuth() && command.Username == "" {
+		return NewError("Vault username is required")
+	}
+	if command.usesPasswordAuth() && command.Password == "" && !canPromptPassword() {
+		return NewError("Vault password is required, set it in the environment or run from a terminal to be prompted")
+	}
 	if command.AuthMethod == "token" && command.AuthToken == "" {
 		return NewError("Vault token is required")
 	}
+	if (command.AuthMethod == "jwt" || command.AuthMethod == "kubernetes") && command.AuthRole == "" {
+		return NewError("Vault auth role is required")
+	}
+	if command.AuthMethod == "kubernetes" {
+		if _, err := os.Stat(command.KubernetesTokenPath); err != nil {
+			return NewError("Kubernetes service account token not found at " + command.KubernetesTokenPath)
+		}
+	}
+	if command.AuthMethod == "jwt" && (command.OidcRequestUrl == "" || command.OidcRequestToken == "") {
+		return NewError("Github OIDC token request url and token are required, make sure the workflow has id-token: write permission")
+	}
+	if command.AuthMethod == "cert" && command.ClientCert == "" {
+		return NewError("Vault TLS client certificate and key are required for cert auth")
+	}
+	if (command.ClientCert == "") != (command.ClientKey == "") {
+		return NewError("Vault TLS client certificate and key must be set together")
+	}
 
 	if command.Base64Kubeconfig == "" {
 		return NewError("Kubeconfig is required")
 	}
+	if command.ForcePrune && !command.Prune {
+		return NewError("Prune force requires prune to be enabled")
+	}
+	if err := command.validateSecretType(); err != nil {
+		return err
+	}
+	if _, err := command.keyRules(); err != nil {
+		return err
+	}
+	if _, err := command.templates(); err != nil {
+		return err
+	}
+	if command.ManifestPath != "" {
+		return command.validateManifest()
+	}
 	if command.Namespace == "" {
 		return NewError("Kubernetes namespace is required")
 	}
+	if command.Recursive {
+		return command.validateRecursive()
+	}
+	if command.ImagePullSecret {
+		if err := command.validateImagePullSecret(); err != nil {
+			return err
+		}
+	}
+	if command.SourceType == SourceTypePki {
+		if err := command.validatePki(); err != nil {
+			return err
+		}
+	}
+	if command.SourceType == SourceTypeDatabase || command.SourceType == SourceTypeDynamic {
+		if err := command.validateLeasedSecret(); err != nil {
+			return err
+		}
+	}
 	if command.ObjectNameToApply == "" {
 		return NewError("Kubernetes object name to apply is required")
 	}
 	return nil
 }
 
+func isSourceType(sourceType string) bool {
+	return sourceType == SourceTypeKv || sourceType == SourceTypePki || sourceType == SourceTypeDatabase || sourceType == SourceTypeDynamic
+}
+
 func NewError(s string) error {
-	return fmt.Errorf(s)
+	return errors.New(s)
 }
 
+// setupLogger logs to stderr, keeping stdout for the plan of a dry run
 func setupLogger() *logrus.Logger {
 	log := logrus.New()
-	log.Out = os.Stdout
+	log.Out = os.Stderr
 	log.Formatter = &logrus.JSONFormatter{}
 	return log
 }
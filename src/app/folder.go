@@ -0,0 +1,143 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const DefaultObjectNameTemplate = "{{ .Path }}"
+
+// objectNameData is exposed to the object name template for every secret found in the folder
+type objectNameData struct {
+	// Path is the secret path relative to the folder, with "/" replaced by "-"
+	Path string
+	// Name is the last segment of the secret path
+	Name string
+	// Dir is the folder of the secret relative to the synced folder, with "/" replaced by "-"
+	Dir string
+}
+
+var invalidObjectNameCharacters = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// loadAndApplyFolder lists the secret folder recursively and applies every secret found as its own object
+func (command Command) loadAndApplyFolder(handler objectHandler) error {
+	log := setupLogger()
+
+	nameTemplate, err := parseObjectNameTemplate(command.ObjectNameTemplate)
+	if err != nil {
+		log.WithError(err).Error("Failed to parse object name template")
+		return err
+	}
+
+	vaultClient, err := command.newVaultClient(log)
+	if err != nil {
+		return err
+	}
+	defer command.releaseVaultClient(vaultClient)
+
+	folder := command.secretSources()[0]
+	secretPaths, err := vaultClient.ListSecrets(folder)
+	if err != nil {
+		return err
+	}
+
+	objectNames := map[string]string{}
+	for _, secretPath := range secretPaths {
+		objectName, err := renderObjectName(nameTemplate, secretPath)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to name object for secret %s", secretPath)
+			return err
+		}
+		if previous, exists := objectNames[objectName]; exists {
+			return fmt.Errorf("secrets %s and %s both resolve to object name %s", previous, secretPath, objectName)
+		}
+		objectNames[objectName] = secretPath
+	}
+
+	kubernetesClient, err := command.createKubernetesClient(log)
+	if err != nil {
+		return err
+	}
+
+	for _, secretPath := range secretPaths {
+		objectName, _ := renderObjectName(nameTemplate, secretPath)
+
+		secret, err := vaultClient.LoadSecretAt(folder.JoinSecretPath(secretPath))
+		if err != nil {
+			return err
+		}
+
+		options := command.applyOptions(secret)
+		data, err := command.objectData(secret.Data, &options)
+		if err != nil {
+			return fmt.Errorf("%s: %w", secretPath, err)
+		}
+
+		err = handler(kubernetesClient, targetObject{
+			kind:    command.objectKind(),
+			name:    objectName,
+			data:    data,
+			options: options,
+		}, log)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"folder":  folder.String(),
+		"objects": len(secretPaths),
+	}).Info("Applied secret folder")
+
+	return nil
+}
+
+func (command Command) validateRecursive() error {
+	if len(command.secretSources()) != 1 {
+		return NewError("Recursive sync requires a single Vault secret folder")
+	}
+	if command.SecretVersion > 0 {
+		return NewError("Vault secret version can not be pinned on recursive sync")
+	}
+	if _, err := parseObjectNameTemplate(command.ObjectNameTemplate); err != nil {
+		return NewError("Object name template is invalid: " + err.Error())
+	}
+	return nil
+}
+
+func parseObjectNameTemplate(nameTemplate string) (*template.Template, error) {
+	return template.New("object-name").Option("missingkey=error").Parse(nameTemplate)
+}
+
+// renderObjectName derives a valid Kubernetes object name from a secret path relative to the synced folder
+func renderObjectName(nameTemplate *template.Template, secretPath string) (string, error) {
+	dir := path.Dir(secretPath)
+	if dir == "." {
+		dir = ""
+	}
+
+	var name bytes.Buffer
+	err := nameTemplate.Execute(&name, objectNameData{
+		Path: strings.ReplaceAll(secretPath, "/", "-"),
+		Name: path.Base(secretPath),
+		Dir:  strings.ReplaceAll(dir, "/", "-"),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	objectName := invalidObjectNameCharacters.ReplaceAllString(strings.ToLower(name.String()), "-")
+	objectName = strings.Trim(objectName, "-.")
+
+	if errs := validation.IsDNS1123Subdomain(objectName); len(errs) > 0 {
+		return "", fmt.Errorf("object name %q is invalid: %s", objectName, strings.Join(errs, ", "))
+	}
+
+	return objectName, nil
+}
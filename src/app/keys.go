@@ -0,0 +1,205 @@
+package app
+
+import (
+	"fmt"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	KeyCaseUpper = "upper"
+	KeyCaseLower = "lower"
+)
+
+// keyRules select and rename the Vault keys before they are written to Kubernetes
+type keyRules struct {
+	include []keyPattern
+	exclude []keyPattern
+	// renames map Vault keys to the final object keys, the case, prefix and suffix rules do not apply to them
+	renames map[string]string
+	keyCase string
+	prefix  string
+	suffix  string
+}
+
+// keyPattern is a glob, or a regular expression when written between slashes like /^DB_/
+type keyPattern struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+func (pattern keyPattern) matches(key string) bool {
+	if pattern.regex != nil {
+		return pattern.regex.MatchString(key)
+	}
+	matched, _ := path.Match(pattern.glob, key)
+	return matched
+}
+
+func parseKeyPatterns(list string) ([]keyPattern, error) {
+	var patterns []keyPattern
+	for _, entry := range parseKeyList(list) {
+		if len(entry) > 1 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+			regex, err := regexp.Compile(entry[1 : len(entry)-1])
+			if err != nil {
+				return nil, NewError(fmt.Sprintf("Key pattern %s is not a valid regular expression: %v", entry, err))
+			}
+			patterns = append(patterns, keyPattern{regex: regex})
+			continue
+		}
+		if _, err := path.Match(entry, ""); err != nil {
+			return nil, NewError(fmt.Sprintf("Key pattern %s is not a valid glob: %v", entry, err))
+		}
+		patterns = append(patterns, keyPattern{glob: entry})
+	}
+	return patterns, nil
+}
+
+// parseKeyRenames reads comma or newline separated vaultKey:objectKey pairs
+func parseKeyRenames(list string) (map[string]string, error) {
+	renames := map[string]string{}
+	for _, pair := range parseKeyList(list) {
+		vaultKey, objectKey, found := strings.Cut(pair, ":")
+		vaultKey, objectKey = strings.TrimSpace(vaultKey), strings.TrimSpace(objectKey)
+		if !found || vaultKey == "" || objectKey == "" {
+			return nil, NewError("Key renames must be in the form vaultKey:objectKey")
+		}
+		renames[vaultKey] = objectKey
+	}
+	return renames, nil
+}
+
+func (command Command) keyRules() (keyRules, error) {
+	include, err := parseKeyPatterns(command.KeyInclude)
+	if err != nil {
+		return keyRules{}, err
+	}
+	exclude, err := parseKeyPatterns(command.KeyExclude)
+	if err != nil {
+		return keyRules{}, err
+	}
+	renames, err := parseKeyRenames(command.KeyRename)
+	if err != nil {
+		return keyRules{}, err
+	}
+	if command.KeyCase != "" && command.KeyCase != KeyCaseUpper && command.KeyCase != KeyCaseLower {
+		return keyRules{}, NewError("Key case must be upper or lower")
+	}
+
+	return keyRules{
+		include: include,
+		exclude: exclude,
+		renames: renames,
+		keyCase: command.KeyCase,
+		prefix:  command.KeyPrefix,
+		suffix:  command.KeySuffix,
+	}, nil
+}
+
+// transformKeys applies the key rules to the Vault data and checks the resulting keys are valid Kubernetes keys,
+// the options naming Vault keys are updated to the transformed keys
+func (command Command) transformKeys(data map[string]string, options *kubernetes.ApplyOptions) (map[string]string, error) {
+	rules, err := command.keyRules()
+	if err != nil {
+		return nil, err
+	}
+	return rules.apply(data, options)
+}
+
+func (rules keyRules) apply(data map[string]string, options *kubernetes.ApplyOptions) (map[string]string, error) {
+	transformed := make(map[string]string, len(data))
+	sources := make(map[string]string, len(data))
+	objectKeys := make(map[string]string, len(data))
+	var renamedBinaryKeys []string
+
+	for _, vaultKey := range sortedKeys(data) {
+		if !rules.selects(vaultKey) {
+			continue
+		}
+
+		objectKey, renamedBinary := rules.objectKey(vaultKey, *options)
+		if previous, exists := sources[objectKey]; exists {
+			return nil, NewError(fmt.Sprintf("Vault keys %s and %s both map to key %s", previous, vaultKey, objectKey))
+		}
+		sources[objectKey] = vaultKey
+		objectKeys[vaultKey] = objectKey
+		transformed[objectKey] = data[vaultKey]
+		if renamedBinary {
+			renamedBinaryKeys = append(renamedBinaryKeys, objectKey)
+		}
+	}
+
+	var invalid []string
+	for objectKey := range transformed {
+		if errs := validation.IsConfigMapKey(objectKey); len(errs) > 0 {
+			invalid = append(invalid, fmt.Sprintf("%q from Vault key %s (%s)", objectKey, sources[objectKey], strings.Join(errs, ", ")))
+		}
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return nil, NewError("Invalid Kubernetes keys: " + strings.Join(invalid, "; "))
+	}
+
+	options.Base64Keys = append(renameKeys(options.Base64Keys, objectKeys), renamedBinaryKeys...)
+	if len(options.SecretKeys) > 0 {
+		secretKeys := make(map[string]string, len(options.SecretKeys))
+		for typeKey, vaultKey := range options.SecretKeys {
+			secretKeys[typeKey] = renameKeys([]string{vaultKey}, objectKeys)[0]
+		}
+		options.SecretKeys = secretKeys
+	}
+	return transformed, nil
+}
+
+// renameKeys replaces the Vault keys by their object keys, keys left out by the rules are kept so that they are reported as missing
+func renameKeys(keys []string, objectKeys map[string]string) []string {
+	renamed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if objectKey, exists := objectKeys[key]; exists {
+			key = objectKey
+		}
+		renamed = append(renamed, key)
+	}
+	return renamed
+}
+
+func (rules keyRules) selects(key string) bool {
+	if len(rules.include) > 0 && !matchesAny(rules.include, key) {
+		return false
+	}
+	return !matchesAny(rules.exclude, key)
+}
+
+// objectKey transforms the name before the base64 suffix, which is kept so that the value is still decoded,
+// a renamed key holding a base64 value drops the suffix and is reported so that it can be listed as a base64 key
+func (rules keyRules) objectKey(vaultKey string, options kubernetes.ApplyOptions) (string, bool) {
+	name, base64Suffix := vaultKey, ""
+	if options.HasBase64Suffix(vaultKey) {
+		name, base64Suffix = strings.TrimSuffix(vaultKey, options.Base64Suffix), options.Base64Suffix
+	}
+
+	if renamed, exists := rules.renames[vaultKey]; exists {
+		return renamed, base64Suffix != ""
+	}
+
+	switch rules.keyCase {
+	case KeyCaseUpper:
+		name = strings.ToUpper(name)
+	case KeyCaseLower:
+		name = strings.ToLower(name)
+	}
+	return rules.prefix + name + rules.suffix + base64Suffix, false
+}
+
+func matchesAny(patterns []keyPattern, key string) bool {
+	for _, pattern := range patterns {
+		if pattern.matches(key) {
+			return true
+		}
+	}
+	return false
+}
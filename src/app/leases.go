@@ -0,0 +1,144 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	vault "k8s-from-secrets-vault/vault"
+	"strconv"
+	"time"
+)
+
+// leasedSecretPath is <engine>/creds/<role> for the database engine, or the engine and path as is for other lease based engines
+func (command Command) leasedSecretPath() string {
+	if command.SourceType == SourceTypeDatabase {
+		return command.EngineName + "/creds/" + command.SecretPath
+	}
+	return command.EngineName + "/" + command.SecretPath
+}
+
+// loadAndApplyLeasedSecret reads new dynamic credentials and records their lease on the applied secret
+func (command Command) loadAndApplyLeasedSecret(handler objectHandler) error {
+	log := setupLogger()
+
+	kubernetesClient, err := command.createKubernetesClient(log)
+	if err != nil {
+		return err
+	}
+
+	existing, err := kubernetesClient.ReadSecret(context.TODO(), command.ObjectNameToApply, log)
+	if err != nil {
+		return err
+	}
+
+	if command.watching && existing != nil && !leaseNeedsRefresh(existing.Annotations, time.Now()) {
+		if command.leaseTokenIsCurrent(existing.Annotations, log) {
+			log.Infof("Lease of secret %s is still fresh, skipping new credentials", command.ObjectNameToApply)
+			return nil
+		}
+		log.Infof("Lease of secret %s belongs to a previous Vault token that expires with it, reading new credentials", command.ObjectNameToApply)
+	}
+
+	object := targetObject{kind: KindSecret, namespace: command.Namespace, name: command.ObjectNameToApply}
+	if command.planning {
+		var keys []string
+		if existing != nil {
+			keys = sortedKeys(existing.Data)
+		}
+		object.plan = issuePlan(object, existing != nil, keys)
+		return handler(kubernetesClient, object, log)
+	}
+
+	vaultClient, err := command.newVaultClient(log)
+	if err != nil {
+		return err
+	}
+	defer command.releaseVaultClient(vaultClient)
+
+	secret, err := vaultClient.ReadLeasedSecret(command.leasedSecretPath())
+	if err != nil {
+		return err
+	}
+
+	object.options = command.applyOptions(vault.LoadedSecret{})
+	object.options.Annotations[kubernetes.VaultLeaseIdAnnotation] = secret.LeaseId
+	validFor := leaseValidFor(secret, log)
+	object.options.Annotations[kubernetes.VaultLeaseTtlAnnotation] = strconv.Itoa(int(validFor.Seconds()))
+	object.options.Annotations[kubernetes.VaultLeaseExpiryAnnotation] = time.Now().Add(validFor).UTC().Format(time.RFC3339)
+	if secret.TokenAccessor != "" {
+		object.options.Annotations[kubernetes.VaultLeaseTokenAnnotation] = secret.TokenAccessor
+	}
+
+	object.data, err = command.objectData(secret.Data, &object.options)
+	if err == nil {
+		err = handler(kubernetesClient, object, log)
+	}
+	if err != nil {
+		// The new credentials never reached the cluster, so nothing can use them
+		if secret.LeaseId != "" {
+			_ = vaultClient.RevokeLease(secret.LeaseId)
+		}
+		return err
+	}
+
+	if command.RevokePreviousLease && existing != nil {
+		previousLeaseId := existing.Annotations[kubernetes.VaultLeaseIdAnnotation]
+		if previousLeaseId != "" && previousLeaseId != secret.LeaseId {
+			err = vaultClient.RevokeLease(previousLeaseId)
+			if err != nil {
+				return fmt.Errorf("new credentials were applied but previous lease %s could not be revoked: %w", previousLeaseId, err)
+			}
+		}
+	}
+	return nil
+}
+
+// leaseValidFor is the time left on the credentials, a lease is revoked with the token that created it so a shorter token ttl caps it
+func leaseValidFor(secret vault.LeasedSecret, log *logrus.Logger) time.Duration {
+	if secret.TokenTtl > 0 && secret.TokenTtl < secret.LeaseDuration {
+		log.Warnf("Vault token expires in %s, before lease %s of %s, recording the token expiry", secret.TokenTtl, secret.LeaseId, secret.LeaseDuration)
+		return secret.TokenTtl
+	}
+	return secret.LeaseDuration
+}
+
+// leaseTokenIsCurrent reports whether the recorded lease was created by the token of the kept client, after a new login the old token and its leases expire
+func (command Command) leaseTokenIsCurrent(annotations map[string]string, log *logrus.Logger) bool {
+	recorded := annotations[kubernetes.VaultLeaseTokenAnnotation]
+	if recorded == "" || command.vaultClient == nil {
+		return true
+	}
+	accessor, err := command.vaultClient.TokenAccessor()
+	if err != nil {
+		log.WithError(err).Warn("Failed to look up the Vault token, keeping the recorded lease")
+		return true
+	}
+	return accessor == recorded
+}
+
+// leaseNeedsRefresh reports whether less than a third of the recorded lease remains, or no lease is recorded
+func leaseNeedsRefresh(annotations map[string]string, now time.Time) bool {
+	expiry, err := time.Parse(time.RFC3339, annotations[kubernetes.VaultLeaseExpiryAnnotation])
+	if err != nil {
+		return true
+	}
+	ttl, err := strconv.Atoi(annotations[kubernetes.VaultLeaseTtlAnnotation])
+	if err != nil {
+		return true
+	}
+	return now.After(expiry.Add(-time.Duration(ttl) * time.Second / 3))
+}
+
+func (command Command) validateLeasedSecret() error {
+	if command.LoadAsConfigMap {
+		return NewError("Vault dynamic credentials can not be loaded as configmap")
+	}
+	if command.ImagePullSecret {
+		return NewError("Vault dynamic credentials can not be used with an image pull secret")
+	}
+	if command.SecretVersion > 0 || len(command.secretSources()) > 1 {
+		return NewError("Vault dynamic credentials take a single secret path")
+	}
+	return nil
+}
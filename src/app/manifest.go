@@ -0,0 +1,301 @@
+package app
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	vault "k8s-from-secrets-vault/vault"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+const (
+	KindSecret    = "Secret"
+	KindConfigMap = "ConfigMap"
+)
+
+// Manifest describes several Vault to Kubernetes mappings synced in a single run
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+}
+
+type ManifestEntry struct {
+	// Path accepts the same [engine:]path list as VAULT_SECRET_PATH
+	Path      string            `json:"path"`
+	Engine    string            `json:"engine,omitempty"`
+	Kind      string            `json:"kind,omitempty"`
+	Type      string            `json:"type,omitempty"`
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	// Keys maps Vault keys to object keys, only the mapped keys are applied when set
+	Keys map[string]string `json:"keys,omitempty"`
+	// Base64Keys lists object keys holding base64 encoded binary values, added to the command wide ones
+	Base64Keys []string `json:"base64Keys,omitempty"`
+}
+
+type manifestEntryResult struct {
+	entry ManifestEntry
+	keys  int
+	err   error
+}
+
+// LoadManifest reads a YAML or JSON manifest file and fills in the defaults of every entry
+func LoadManifest(path string, defaultEngine string, defaultNamespace string) (Manifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	err = yaml.UnmarshalStrict(content, &manifest)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	for i := range manifest.Entries {
+		entry := &manifest.Entries[i]
+		if entry.Engine == "" {
+			entry.Engine = defaultEngine
+		}
+		if entry.Namespace == "" {
+			entry.Namespace = defaultNamespace
+		}
+		if entry.Kind == "" {
+			entry.Kind = KindSecret
+		}
+	}
+
+	return manifest, nil
+}
+
+// Validate checks every entry up front so that a run never applies a partially valid manifest
+func (manifest Manifest) Validate() error {
+	if len(manifest.Entries) == 0 {
+		return fmt.Errorf("manifest has no entries")
+	}
+
+	var problems []string
+	objects := map[string]int{}
+
+	for i, entry := range manifest.Entries {
+		for _, problem := range entry.validate() {
+			problems = append(problems, fmt.Sprintf("entry %d: %s", i, problem))
+		}
+
+		object := entry.Kind + "/" + entry.Namespace + "/" + entry.Name
+		if previous, exists := objects[object]; exists {
+			problems = append(problems, fmt.Sprintf("entry %d: %s is already defined by entry %d", i, object, previous))
+		}
+		objects[object] = i
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (entry ManifestEntry) validate() []string {
+	var problems []string
+
+	if entry.Name == "" {
+		problems = append(problems, "name is required")
+	} else if errs := validation.IsDNS1123Subdomain(entry.Name); len(errs) > 0 {
+		problems = append(problems, fmt.Sprintf("name %q is invalid: %s", entry.Name, strings.Join(errs, ", ")))
+	}
+	if entry.Namespace == "" {
+		problems = append(problems, "namespace is required")
+	} else if errs := validation.IsDNS1123Label(entry.Namespace); len(errs) > 0 {
+		problems = append(problems, fmt.Sprintf("namespace %q is invalid: %s", entry.Namespace, strings.Join(errs, ", ")))
+	}
+	if entry.Kind != KindSecret && entry.Kind != KindConfigMap {
+		problems = append(problems, fmt.Sprintf("kind must be %s or %s", KindSecret, KindConfigMap))
+	}
+	if entry.Type != "" && entry.Kind != KindSecret {
+		problems = append(problems, "type can only be set on a Secret")
+	} else if entry.Type != "" && !kubernetes.IsSecretType(entry.Type) {
+		problems = append(problems, fmt.Sprintf("type must be one of %s", strings.Join(kubernetes.SecretTypes(), ", ")))
+	}
+	if entry.Engine == "" {
+		problems = append(problems, "engine is required")
+	}
+	if len(vault.ParseSecretSources(entry.Path, entry.Engine)) == 0 {
+		problems = append(problems, "path is required")
+	}
+	for key, value := range entry.Labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("label %q is invalid: %s", key, strings.Join(errs, ", ")))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("label %q value is invalid: %s", key, strings.Join(errs, ", ")))
+		}
+	}
+	mappedFrom := map[string]string{}
+	for _, vaultKey := range sortedKeys(entry.Keys) {
+		objectKey := entry.Keys[vaultKey]
+		if errs := validation.IsConfigMapKey(objectKey); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("key mapping %s:%s is invalid: %s", vaultKey, objectKey, strings.Join(errs, ", ")))
+		}
+		if previous, exists := mappedFrom[objectKey]; exists {
+			problems = append(problems, fmt.Sprintf("keys %s and %s both map to %s", previous, vaultKey, objectKey))
+		}
+		mappedFrom[objectKey] = vaultKey
+	}
+
+	return problems
+}
+
+func (entry ManifestEntry) String() string {
+	return entry.Kind + "/" + entry.Namespace + "/" + entry.Name
+}
+
+// loadAndApplyManifest executes the manifest entries in order, carrying on after a failed entry
+func (command Command) loadAndApplyManifest(handler objectHandler) error {
+	log := setupLogger()
+
+	manifest, err := LoadManifest(command.ManifestPath, command.EngineName, command.Namespace)
+	if err != nil {
+		log.WithError(err).Error("Failed to load manifest")
+		return err
+	}
+	err = manifest.Validate()
+	if err != nil {
+		log.WithError(err).Error("Failed to validate manifest")
+		return err
+	}
+
+	vaultClient, err := command.newVaultClient(log)
+	if err != nil {
+		return err
+	}
+	defer command.releaseVaultClient(vaultClient)
+
+	kubernetesClient, err := command.createKubernetesClient(log)
+	if err != nil {
+		return err
+	}
+
+	var results []manifestEntryResult
+	for _, entry := range manifest.Entries {
+		keys, err := command.applyManifestEntry(entry, vaultClient, kubernetesClient, handler, log)
+		results = append(results, manifestEntryResult{entry: entry, keys: keys, err: err})
+	}
+
+	return summarizeManifestResults(results, log)
+}
+
+func (command Command) applyManifestEntry(entry ManifestEntry, vaultClient *vault.Client, kubernetesClient kubernetes.KubernetesClient, handler objectHandler, log *logrus.Logger) (int, error) {
+	secret, err := vaultClient.LoadSources(vault.ParseSecretSources(entry.Path, entry.Engine))
+	if err != nil {
+		return 0, err
+	}
+
+	// The command wide key rules and templates run first, so the entry keys refer to the resulting keys
+	options := command.applyOptions(secret)
+	data, err := command.objectData(secret.Data, &options)
+	if err != nil {
+		return 0, err
+	}
+	data, err = mapKeys(data, entry.Keys)
+	if err != nil {
+		return 0, err
+	}
+	options.Base64Keys = mapBase64Keys(options, entry.Keys)
+
+	options.Labels = entry.Labels
+	options.Base64Keys = append(options.Base64Keys, entry.Base64Keys...)
+	if entry.Type != "" {
+		options.SecretType = entry.Type
+		options.SecretKeys = nil
+	}
+
+	err = handler(kubernetesClient, targetObject{
+		kind:      entry.Kind,
+		namespace: entry.Namespace,
+		name:      entry.Name,
+		data:      data,
+		options:   options,
+	}, log)
+
+	return len(data), err
+}
+
+// mapKeys keeps and renames only the mapped keys, or returns the data untouched when there is no mapping
+func mapKeys(data map[string]string, keys map[string]string) (map[string]string, error) {
+	if len(keys) == 0 {
+		return data, nil
+	}
+
+	mapped := make(map[string]string, len(keys))
+	for vaultKey, objectKey := range keys {
+		value, ok := data[vaultKey]
+		if !ok {
+			return nil, fmt.Errorf("key %s not found in vault secret", vaultKey)
+		}
+		mapped[objectKey] = value
+	}
+	return mapped, nil
+}
+
+// mapBase64Keys follows the entry key mapping for the keys holding base64 values, so that a renamed value is still decoded
+func mapBase64Keys(options kubernetes.ApplyOptions, keys map[string]string) []string {
+	if len(keys) == 0 {
+		return options.Base64Keys
+	}
+
+	listed := make(map[string]bool, len(options.Base64Keys))
+	for _, key := range options.Base64Keys {
+		listed[key] = true
+	}
+	var mapped []string
+	for _, key := range sortedKeys(keys) {
+		objectKey := keys[key]
+		if listed[key] || options.HasBase64Suffix(key) && !options.HasBase64Suffix(objectKey) {
+			mapped = append(mapped, objectKey)
+		}
+	}
+	return mapped
+}
+
+func summarizeManifestResults(results []manifestEntryResult, log *logrus.Logger) error {
+	failed := 0
+	for _, result := range results {
+		fields := logrus.Fields{
+			"object": result.entry.String(),
+			"path":   result.entry.Path,
+		}
+		if result.err != nil {
+			failed++
+			log.WithFields(fields).WithError(result.err).Error("Manifest entry failed")
+			continue
+		}
+		fields["keys"] = result.keys
+		log.WithFields(fields).Info("Manifest entry applied")
+	}
+
+	log.WithFields(logrus.Fields{
+		"entries": len(results),
+		"applied": len(results) - failed,
+		"failed":  failed,
+	}).Info("Manifest sync finished")
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d manifest entries failed", failed, len(results))
+	}
+	return nil
+}
+
+func (command Command) validateManifest() error {
+	manifest, err := LoadManifest(command.ManifestPath, command.EngineName, command.Namespace)
+	if err != nil {
+		return NewError("Sync manifest could not be loaded: " + err.Error())
+	}
+	err = manifest.Validate()
+	if err != nil {
+		return NewError("Sync manifest is invalid: " + err.Error())
+	}
+	return nil
+}
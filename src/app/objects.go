@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	"sort"
+)
+
+// targetObject is a Kubernetes object built from Vault data, applied to the command namespace when namespace is empty
+type targetObject struct {
+	kind      string
+	namespace string
+	name      string
+	data      map[string]string
+	options   kubernetes.ApplyOptions
+	// plan is set instead of data when Vault only generates the data on apply, so planning must not request it
+	plan *kubernetes.Plan
+}
+
+// objectHandler receives every object a sync produces, either applying or planning it
+type objectHandler func(client kubernetes.KubernetesClient, object targetObject, log *logrus.Logger) error
+
+func applyObject(client kubernetes.KubernetesClient, object targetObject, log *logrus.Logger) error {
+	if object.namespace != "" {
+		client = client.InNamespace(object.namespace)
+	}
+
+	if object.kind == KindConfigMap {
+		return client.ApplyConfigMap(context.TODO(), object.name, object.data, object.options, log)
+	}
+	return client.ApplySecret(context.TODO(), object.name, object.data, object.options, log)
+}
+
+// planObject writes the plan of every object to out, flagging pending when any of them would change
+func planObject(out io.Writer, pending *bool) objectHandler {
+	return func(client kubernetes.KubernetesClient, object targetObject, log *logrus.Logger) error {
+		if object.plan != nil {
+			*pending = true
+			_, err := fmt.Fprint(out, object.plan.String())
+			return err
+		}
+
+		plan, err := planTarget(client, object, log)
+		if err != nil {
+			return err
+		}
+
+		if plan.HasChanges() {
+			*pending = true
+		}
+		_, err = fmt.Fprint(out, plan.String())
+		return err
+	}
+}
+
+// planTarget compares the object with the live object in the cluster
+func planTarget(client kubernetes.KubernetesClient, object targetObject, log *logrus.Logger) (kubernetes.Plan, error) {
+	if object.namespace != "" {
+		client = client.InNamespace(object.namespace)
+	}
+
+	if object.kind == KindConfigMap {
+		return client.PlanConfigMap(context.TODO(), object.name, object.data, object.options, log)
+	}
+	return client.PlanSecret(context.TODO(), object.name, object.data, object.options, log)
+}
+
+// issuePlan is the plan of an object whose keys Vault generates anew on every apply
+func issuePlan(object targetObject, exists bool, keys []string) *kubernetes.Plan {
+	plan := &kubernetes.Plan{Kind: object.kind, Namespace: object.namespace, Name: object.name, Create: !exists}
+	if exists {
+		plan.Data.Changed = keys
+	} else {
+		plan.Data.Added = keys
+	}
+	return plan
+}
+
+func sortedKeys(data map[string]string) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"fmt"
+	"golang.org/x/term"
+	"os"
+)
+
+func (command Command) usesPasswordAuth() bool {
+	return command.AuthMethod == "userpass" || command.AuthMethod == "ldap"
+}
+
+// canPromptPassword reports whether a missing password can be read from the terminal once a command logs in
+func canPromptPassword() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// promptPassword reads the Vault password from the terminal without echoing it, returning it empty when stdin is not a terminal
+func promptPassword(username string) (string, error) {
+	stdin := int(os.Stdin.Fd())
+	if !term.IsTerminal(stdin) {
+		return "", nil
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "Vault password for %s: ", username)
+	password, err := term.ReadPassword(stdin)
+	_, _ = fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
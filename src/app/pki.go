@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	vault "k8s-from-secrets-vault/vault"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+	"time"
+)
+
+const caCertificateKey = "ca.crt"
+
+// loadAndApplyCertificate issues a certificate from the PKI engine, using the secret path as role, unless the applied one is still valid
+func (command Command) loadAndApplyCertificate(handler objectHandler) error {
+	log := setupLogger()
+
+	kubernetesClient, err := command.createKubernetesClient(log)
+	if err != nil {
+		return err
+	}
+
+	existing, err := kubernetesClient.ReadSecret(context.TODO(), command.ObjectNameToApply, log)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		existing = &kubernetes.ExistingSecret{}
+	}
+	altNames := strings.FieldsFunc(strings.ReplaceAll(command.PkiAltNames, " ", ""), isListSeparator)
+	if applied := parseCertificatePem(existing.Data[corev1.TLSCertKey]); applied != nil {
+		renewAt := certificateRenewalTime(applied, command.PkiRenewBefore)
+		if !certificateMatchesNames(applied, command.PkiCommonName, altNames) {
+			log.Infof("Certificate in secret %s does not match the requested names, re-issuing", command.ObjectNameToApply)
+		} else if time.Now().Before(renewAt) {
+			log.Infof("Certificate in secret %s is valid until its renewal at %s, skipping issue", command.ObjectNameToApply, renewAt.Format(time.RFC3339))
+			return nil
+		}
+	}
+
+	object := targetObject{kind: KindSecret, namespace: command.Namespace, name: command.ObjectNameToApply}
+	if command.planning {
+		object.plan = issuePlan(object, existing.Data != nil, []string{caCertificateKey, corev1.TLSCertKey, corev1.TLSPrivateKeyKey})
+		return handler(kubernetesClient, object, log)
+	}
+
+	vaultClient, err := command.newVaultClient(log)
+	if err != nil {
+		return err
+	}
+	defer command.releaseVaultClient(vaultClient)
+
+	certificate, err := vaultClient.IssueCertificate(vault.CertificateRequest{
+		EngineName: command.EngineName,
+		Role:       command.SecretPath,
+		CommonName: command.PkiCommonName,
+		AltNames:   altNames,
+		Ttl:        command.PkiTtl,
+	})
+	if err != nil {
+		return err
+	}
+
+	object.data = map[string]string{
+		corev1.TLSCertKey:       certificate.Certificate,
+		corev1.TLSPrivateKeyKey: certificate.PrivateKey,
+		caCertificateKey:        certificate.IssuingCa,
+	}
+	object.options = command.applyOptions(vault.LoadedSecret{})
+	object.options.SecretType = kubernetes.SecretTypeTls
+	object.options.SecretKeys = nil
+
+	return handler(kubernetesClient, object, log)
+}
+
+// parseCertificatePem returns the PEM certificate, or nil when there is none or it can not be parsed
+func parseCertificatePem(certificatePem string) *x509.Certificate {
+	block, _ := pem.Decode([]byte(certificatePem))
+	if block == nil {
+		return nil
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return certificate
+}
+
+// certificateRenewalTime returns when a certificate is due for renewal, which defaults to a third of its lifetime before expiry
+func certificateRenewalTime(certificate *x509.Certificate, renewBefore time.Duration) time.Time {
+	if renewBefore == 0 {
+		renewBefore = certificate.NotAfter.Sub(certificate.NotBefore) / 3
+	}
+	return certificate.NotAfter.Add(-renewBefore)
+}
+
+// certificateMatchesNames checks the certificate was issued for the common name and alt names, Vault also adds the common name to the SANs
+func certificateMatchesNames(certificate *x509.Certificate, commonName string, altNames []string) bool {
+	if certificate.Subject.CommonName != commonName {
+		return false
+	}
+
+	requested := namesOtherThan(altNames, commonName)
+	issued := namesOtherThan(certificate.DNSNames, commonName)
+	if len(requested) != len(issued) {
+		return false
+	}
+	for name := range requested {
+		if !issued[name] {
+			return false
+		}
+	}
+	return true
+}
+
+func namesOtherThan(names []string, commonName string) map[string]bool {
+	others := map[string]bool{}
+	for _, name := range names {
+		if name != commonName {
+			others[name] = true
+		}
+	}
+	return others
+}
+
+func (command Command) validatePki() error {
+	if command.PkiCommonName == "" {
+		return NewError("Vault PKI common name is required")
+	}
+	if command.LoadAsConfigMap {
+		return NewError("Vault PKI certificates can not be loaded as configmap")
+	}
+	if command.SecretType != "" && command.SecretType != kubernetes.SecretTypeTls {
+		return NewError(fmt.Sprintf("Vault PKI certificates are always applied as %s secrets", kubernetes.SecretTypeTls))
+	}
+	if command.ImagePullSecret {
+		return NewError("Vault PKI source can not be used with an image pull secret")
+	}
+	if command.SecretVersion > 0 || len(command.secretSources()) > 1 {
+		return NewError("Vault PKI source takes a single role as secret path")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"fmt"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	vault "k8s-from-secrets-vault/vault"
+)
+
+// loadAndApplyImagePullSecret reads one registry per secret path and applies them as a single dockerconfigjson secret
+func (command Command) loadAndApplyImagePullSecret(handler objectHandler) error {
+	log := setupLogger()
+
+	vaultClient, err := command.newVaultClient(log)
+	if err != nil {
+		return err
+	}
+	defer command.releaseVaultClient(vaultClient)
+
+	keys := kubernetes.NewRegistryKeys(parseKeyMapping(command.RegistryKeyMapping))
+
+	var registries []kubernetes.RegistryCredentials
+	for _, source := range command.secretSources() {
+		secret, err := vaultClient.LoadSecretAt(source)
+		if err != nil {
+			return err
+		}
+
+		credentials, err := keys.Credentials(secret.Data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		log.Infof("Loaded registry %s credentials from %s", credentials.Server, source)
+		registries = append(registries, credentials)
+	}
+
+	data, err := kubernetes.DockerConfigJsonData(registries)
+	if err != nil {
+		return err
+	}
+
+	kubernetesClient, err := command.createKubernetesClient(log)
+	if err != nil {
+		return err
+	}
+
+	options := command.applyOptions(vault.LoadedSecret{})
+	options.SecretType = kubernetes.SecretTypeDockerConfigJson
+	options.SecretKeys = nil
+
+	return handler(kubernetesClient, targetObject{
+		kind:    KindSecret,
+		name:    command.ObjectNameToApply,
+		data:    data,
+		options: options,
+	}, log)
+}
+
+func (command Command) validateImagePullSecret() error {
+	if command.LoadAsConfigMap {
+		return NewError("Image pull secret can not be loaded as configmap")
+	}
+	if command.SecretType != "" && command.SecretType != kubernetes.SecretTypeDockerConfigJson {
+		return NewError("Image pull secret type is always " + kubernetes.SecretTypeDockerConfigJson)
+	}
+	if command.SecretVersion > 0 {
+		return NewError("Vault secret version can not be used with an image pull secret")
+	}
+	if !isKeyMapping(command.RegistryKeyMapping) {
+		return NewError("Registry key mapping entries must be in the form key=vaultKey")
+	}
+	for key := range parseKeyMapping(command.RegistryKeyMapping) {
+		if !kubernetes.IsRegistryKey(key) {
+			return NewError(fmt.Sprintf("Registry key mapping key %s must be one of server, username, password, email", key))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"fmt"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	"strings"
+)
+
+// parseKeyMapping reads comma or newline separated key=vaultKey pairs
+func parseKeyMapping(mapping string) map[string]string {
+	keys := map[string]string{}
+	for _, pair := range strings.FieldsFunc(mapping, isListSeparator) {
+		typeKey, vaultKey, _ := strings.Cut(pair, "=")
+		keys[strings.TrimSpace(typeKey)] = strings.TrimSpace(vaultKey)
+	}
+	return keys
+}
+
+// parseKeyList reads comma or newline separated keys
+func parseKeyList(list string) []string {
+	var keys []string
+	for _, key := range strings.FieldsFunc(list, isListSeparator) {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func isListSeparator(r rune) bool {
+	return r == ',' || r == '\n'
+}
+
+func (command Command) validateSecretType() error {
+	if command.SecretType == "" && command.SecretKeyMapping == "" {
+		return nil
+	}
+	if command.LoadAsConfigMap {
+		return NewError("Secret type and key mapping can not be used when loading as configmap")
+	}
+
+	secretType := command.SecretType
+	if secretType == "" {
+		secretType = kubernetes.SecretTypeOpaque
+	}
+	if !kubernetes.IsSecretType(secretType) {
+		return NewError(fmt.Sprintf("Secret type must be one of %s", strings.Join(kubernetes.SecretTypes(), ", ")))
+	}
+
+	if !isKeyMapping(command.SecretKeyMapping) {
+		return NewError("Secret key mapping entries must be in the form typeKey=vaultKey")
+	}
+	for typeKey := range parseKeyMapping(command.SecretKeyMapping) {
+		if secretType != kubernetes.SecretTypeOpaque && !kubernetes.IsSecretTypeKey(secretType, typeKey) {
+			return NewError(fmt.Sprintf("Secret key mapping key %s is not a key of secret type %s", typeKey, secretType))
+		}
+	}
+	return nil
+}
+
+func isKeyMapping(mapping string) bool {
+	for _, pair := range strings.FieldsFunc(mapping, isListSeparator) {
+		key, vaultKey, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(key) == "" || strings.TrimSpace(vaultKey) == "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"text/template"
+)
+
+// keyTemplate renders the Vault data into a single object key, like application.properties
+type keyTemplate struct {
+	key      string
+	template *template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	"toJson":  toJson,
+	"toYaml":  toYaml,
+	"b64enc":  b64enc,
+	"indent":  indent,
+	"default": defaultValue,
+}
+
+// templates parses the inline template and the template files, a file is written to the key named after it without a .tmpl or .tpl extension unless given as key=path
+func (command Command) templates() ([]keyTemplate, error) {
+	var templates []keyTemplate
+	if command.Template != "" {
+		if command.TemplateKey == "" {
+			return nil, NewError("Template key is required for an inline template")
+		}
+		parsed, err := parseKeyTemplate(command.TemplateKey, command.Template)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, parsed)
+	} else if command.TemplateKey != "" {
+		return nil, NewError("Template key is only used with an inline template")
+	}
+
+	for _, entry := range parseKeyList(command.TemplateFiles) {
+		key, path, found := strings.Cut(entry, "=")
+		if !found {
+			path = entry
+			key = strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".tmpl"), ".tpl")
+		}
+		key, path = strings.TrimSpace(key), strings.TrimSpace(path)
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, NewError(fmt.Sprintf("Failed to read template file %s: %v", path, err))
+		}
+		parsed, err := parseKeyTemplate(key, string(content))
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, parsed)
+	}
+
+	seen := map[string]bool{}
+	for _, parsed := range templates {
+		if seen[parsed.key] {
+			return nil, NewError(fmt.Sprintf("Template key %s is defined more than once", parsed.key))
+		}
+		seen[parsed.key] = true
+	}
+	return templates, nil
+}
+
+func parseKeyTemplate(key string, text string) (keyTemplate, error) {
+	if errs := validation.IsConfigMapKey(key); len(errs) > 0 {
+		return keyTemplate{}, NewError(fmt.Sprintf("Template key %q is not a valid Kubernetes key: %s", key, strings.Join(errs, ", ")))
+	}
+	// Missing keys render empty instead of <no value>, so that default can fill them in
+	parsed, err := template.New(key).Option("missingkey=zero").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return keyTemplate{}, NewError(fmt.Sprintf("Template for key %s is invalid: %v", key, err))
+	}
+	return keyTemplate{key: key, template: parsed}, nil
+}
+
+// objectData applies the key rules to the Vault data and adds the rendered templates, which always see the Vault data as loaded
+func (command Command) objectData(data map[string]string, options *kubernetes.ApplyOptions) (map[string]string, error) {
+	transformed, err := command.transformKeys(data, options)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := command.templates()
+	if err != nil {
+		return nil, err
+	}
+	for _, keyTemplate := range templates {
+		if _, exists := transformed[keyTemplate.key]; exists {
+			return nil, NewError(fmt.Sprintf("Template key %s collides with a Vault key, exclude or rename it", keyTemplate.key))
+		}
+		var rendered bytes.Buffer
+		if err := keyTemplate.template.Execute(&rendered, data); err != nil {
+			return nil, NewError(fmt.Sprintf("Failed to render template for key %s: %v", keyTemplate.key, err))
+		}
+		transformed[keyTemplate.key] = rendered.String()
+	}
+	return transformed, nil
+}
+
+func toJson(value interface{}) (string, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
+
+func toYaml(value interface{}) (string, error) {
+	content, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(content), "\n"), nil
+}
+
+func b64enc(value string) string {
+	return base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+// indent pads every line of the value, used to nest values in YAML templates
+func indent(spaces int, value string) string {
+	padding := strings.Repeat(" ", spaces)
+	return padding + strings.ReplaceAll(value, "\n", "\n"+padding)
+}
+
+// defaultValue returns the fallback when the value is missing or empty, as in {{ .LOG_LEVEL | default "info" }}
+func defaultValue(fallback interface{}, value interface{}) interface{} {
+	if value == nil || value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	"math/rand"
+	"time"
+)
+
+const DefaultWatchInterval = 5 * time.Minute
+
+// appliedContent remembers a hash of the content last applied to every object
+type appliedContent map[string]string
+
+// Watch keeps syncing on the watch interval until the context is cancelled, re-applying only objects whose content changed or whose live object was edited
+func (command Command) Watch(ctx context.Context) error {
+	log := setupLogger()
+	command.watching = true
+
+	vaultClient, err := command.newVaultClient(log)
+	if err != nil {
+		return err
+	}
+	command.vaultClient = vaultClient
+	defer vaultClient.Close()
+	vaultClient.KeepTokenAlive(ctx)
+
+	applied := appliedContent{}
+	for {
+		err := command.sync(applied.applyChanged(applyObject))
+		if err != nil {
+			log.WithError(err).Error("Sync failed, retrying on the next interval")
+		}
+
+		wait := command.nextWatchWait()
+		log.WithField("wait", wait.String()).Info("Waiting for the next sync")
+		select {
+		case <-ctx.Done():
+			log.Info("Watch stopped")
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// nextWatchWait adds a random jitter to the interval so that several instances do not hit Vault at once
+func (command Command) nextWatchWait() time.Duration {
+	if command.WatchJitter <= 0 {
+		return command.WatchInterval
+	}
+	return command.WatchInterval + time.Duration(rand.Int63n(int64(command.WatchJitter)))
+}
+
+func (applied appliedContent) applyChanged(handler objectHandler) objectHandler {
+	return func(client kubernetes.KubernetesClient, object targetObject, log *logrus.Logger) error {
+		key := object.kind + "/" + object.namespace + "/" + object.name
+		hash, err := contentHash(object)
+		if err != nil {
+			return err
+		}
+		if applied[key] == hash {
+			// The hash only tells the Vault content apart, a direct edit of the cluster shows up in the live object
+			plan, err := planTarget(client, object, log)
+			if err != nil {
+				delete(applied, key)
+				return err
+			}
+			if !plan.HasChanges() {
+				log.Debugf("Content of %s is unchanged, skipping apply", key)
+				return nil
+			}
+			log.Infof("Live %s differs from the applied content, applying it again", key)
+		}
+
+		err = handler(client, object, log)
+		if err != nil {
+			delete(applied, key)
+			return err
+		}
+		applied[key] = hash
+		return nil
+	}
+}
+
+func contentHash(object targetObject) (string, error) {
+	content, err := json.Marshal(struct {
+		Data    map[string]string
+		Options kubernetes.ApplyOptions
+	}{object.data, object.options})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
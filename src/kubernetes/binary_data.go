@@ -0,0 +1,98 @@
+package kubernetes_client
+
+import (
+	"encoding/base64"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"sort"
+	"strings"
+)
+
+// objectData splits the object values into text, written as stringData or data, and decoded binary values
+type objectData struct {
+	text   map[string]string
+	binary map[string][]byte
+}
+
+// splitBinaryData decodes the base64 values marked by the options into binary data, the other values stay text
+func splitBinaryData(data map[string]string, options ApplyOptions) (objectData, error) {
+	split := objectData{text: make(map[string]string, len(data)), binary: map[string][]byte{}}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		binaryKey, isBinary := options.binaryKey(key)
+		if !isBinary {
+			split.text[key] = data[key]
+			continue
+		}
+
+		// Encoders like base64 wrap long output in lines, the whitespace is not part of the value
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data[key]), ""))
+		if err != nil {
+			return objectData{}, fmt.Errorf("value of key %s is not valid base64: %w", key, err)
+		}
+		split.binary[binaryKey] = decoded
+	}
+
+	for key := range split.binary {
+		if _, exists := split.text[key]; exists {
+			return objectData{}, fmt.Errorf("key %s is defined both as text and as a base64 value", key)
+		}
+	}
+	return split, nil
+}
+
+// binaryKey returns the object key of a base64 encoded value, with the suffix removed
+func (options ApplyOptions) binaryKey(key string) (string, bool) {
+	for _, binaryKey := range options.Base64Keys {
+		if key == binaryKey {
+			return key, true
+		}
+	}
+	if options.HasBase64Suffix(key) {
+		return strings.TrimSuffix(key, options.Base64Suffix), true
+	}
+	return "", false
+}
+
+// HasBase64Suffix reports whether the key is marked as holding a base64 value by the suffix
+func (options ApplyOptions) HasBase64Suffix(key string) bool {
+	return options.Base64Suffix != "" && strings.HasSuffix(key, options.Base64Suffix) && key != options.Base64Suffix
+}
+
+// values returns every value as a string, binary values included, to compare them with the live object
+func (data objectData) values() map[string]string {
+	values := make(map[string]string, len(data.text)+len(data.binary))
+	for key, value := range data.text {
+		values[key] = value
+	}
+	for key, value := range data.binary {
+		values[key] = string(value)
+	}
+	return values
+}
+
+// binaryOrNil leaves the binary field out of the object when there are no binary values
+func (data objectData) binaryOrNil() map[string][]byte {
+	if len(data.binary) == 0 {
+		return nil
+	}
+	return data.binary
+}
+
+// configMapData returns the config-map data together with its binary data
+func configMapData(configmap *corev1.ConfigMap) map[string]string {
+	data := make(map[string]string, len(configmap.Data)+len(configmap.BinaryData))
+	for key, value := range configmap.Data {
+		data[key] = value
+	}
+	for key, value := range configmap.BinaryData {
+		data[key] = string(value)
+	}
+	return data
+}
@@ -3,10 +3,13 @@ package kubernetes_client
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	applyv1 "k8s.io/client-go/applyconfigurations/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -14,8 +17,28 @@ import (
 )
 
 type KubernetesClient interface {
-	ApplySecret(context context.Context, secretName string, secretData map[string]string, log *logrus.Logger) error
-	ApplyConfigMap(context context.Context, configName string, configData map[string]string, log *logrus.Logger) error
+	ApplySecret(context context.Context, secretName string, secretData map[string]string, options ApplyOptions, log *logrus.Logger) error
+	ApplyConfigMap(context context.Context, configName string, configData map[string]string, options ApplyOptions, log *logrus.Logger) error
+	PlanSecret(context context.Context, secretName string, secretData map[string]string, options ApplyOptions, log *logrus.Logger) (Plan, error)
+	PlanConfigMap(context context.Context, configName string, configData map[string]string, options ApplyOptions, log *logrus.Logger) (Plan, error)
+	ReadSecret(context context.Context, secretName string, log *logrus.Logger) (*ExistingSecret, error)
+	InNamespace(namespace string) KubernetesClient
+}
+
+// ApplyOptions holds the per-object settings applied together with the object data
+type ApplyOptions struct {
+	Annotations map[string]string
+	Labels      map[string]string
+	// Prune removes the object keys missing from the data, ForcePrune also removes keys owned by other field managers
+	Prune      bool
+	ForcePrune bool
+	// SecretType defaults to Opaque, SecretKeys maps the type keys to the Vault keys holding their values
+	SecretType string
+	SecretKeys map[string]string
+	// Base64Suffix marks the keys holding base64 encoded binary values and is removed from their name once decoded,
+	// Base64Keys lists keys decoded under the same name
+	Base64Suffix string
+	Base64Keys   []string
 }
 type kubernetesClient struct {
 	config     KubernetesConfig
@@ -38,6 +61,21 @@ const APPLY = false
 
 const fieldManagerName = "k8s-from-secrets-vault"
 
+const (
+	updatedByAnnotation          = "app.kubernetes.io/update-by"
+	VaultSecretVersionAnnotation = "k8s-from-secrets-vault/vault-secret-version"
+	VaultLeaseIdAnnotation       = "k8s-from-secrets-vault/vault-lease-id"
+	VaultLeaseTtlAnnotation      = "k8s-from-secrets-vault/vault-lease-ttl"
+	VaultLeaseExpiryAnnotation   = "k8s-from-secrets-vault/vault-lease-expiry"
+	VaultLeaseTokenAnnotation    = "k8s-from-secrets-vault/vault-lease-token-accessor"
+)
+
+// ExistingSecret is the data and annotations of a secret already in the cluster
+type ExistingSecret struct {
+	Data        map[string]string
+	Annotations map[string]string
+}
+
 func InjectKubernetesClient(client kubernetes.Interface, config KubernetesConfig) KubernetesClient {
 	return kubernetesClient{config, client, CREATE}
 }
@@ -88,13 +126,52 @@ func (conf KubernetesConfig) GetServer() string {
 	return conf.restConfig.Host
 }
 
-func (c kubernetesClient) ApplySecret(context context.Context, secretName string, secretData map[string]string, log *logrus.Logger) error {
-	var createdSecret, err = &corev1.Secret{}, error(nil)
+// InNamespace returns a client that applies objects to the given namespace
+func (c kubernetesClient) InNamespace(namespace string) KubernetesClient {
+	c.config.namespace = namespace
+	return c
+}
+
+// ReadSecret returns an existing secret, or nil when the secret does not exist
+func (c kubernetesClient) ReadSecret(context context.Context, secretName string, log *logrus.Logger) (*ExistingSecret, error) {
+	secret, err := c.client.CoreV1().Secrets(c.config.namespace).Get(context, secretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Errorf("Error reading secret: %v", err)
+		return nil, err
+	}
+	return &ExistingSecret{Data: secretStringData(secret), Annotations: secret.Annotations}, nil
+}
+
+func (c kubernetesClient) ApplySecret(context context.Context, secretName string, secretData map[string]string, options ApplyOptions, log *logrus.Logger) error {
+	secretData, err := typedSecretData(secretData, options)
+	if err != nil {
+		log.Errorf("Error validating Secret: %v", err)
+		return err
+	}
+	data, err := splitBinaryData(secretData, options)
+	if err != nil {
+		log.Errorf("Error decoding Secret: %v", err)
+		return err
+	}
+
+	var stale []string
+	if options.Prune {
+		stale, err = c.staleSecretKeys(context, secretName, data.values(), options)
+		if err != nil {
+			log.Errorf("Error pruning Secret: %v", err)
+			return err
+		}
+	}
+
+	var createdSecret = &corev1.Secret{}
 
 	if c.commitMode == CREATE {
-		createdSecret, err = c.createSecret(context, secretName, secretData, log)
+		createdSecret, err = c.createSecret(context, secretName, data, options, log)
 	} else if c.commitMode == APPLY {
-		createdSecret, err = c.applySecret(context, secretName, secretData, log)
+		createdSecret, err = c.applySecret(context, secretName, data, options, log)
 	}
 
 	if err != nil {
@@ -105,16 +182,40 @@ func (c kubernetesClient) ApplySecret(context context.Context, secretName string
 		log.Errorf("Error applying Secret: %v", err)
 		return err
 	}
+
+	if options.Prune {
+		err = c.pruneSecret(context, secretName, stale, log)
+		if err != nil {
+			log.Errorf("Error pruning Secret: %v", err)
+			return err
+		}
+		log.WithField("pruned", stale).Infof("Pruned %d keys from secret %s", len(stale), secretName)
+	}
 	return nil
 }
 
-func (c kubernetesClient) ApplyConfigMap(context context.Context, configName string, configData map[string]string, log *logrus.Logger) error {
-	var createdConfigMap, err = &corev1.ConfigMap{}, error(nil)
+func (c kubernetesClient) ApplyConfigMap(context context.Context, configName string, configData map[string]string, options ApplyOptions, log *logrus.Logger) error {
+	data, err := splitBinaryData(configData, options)
+	if err != nil {
+		log.Errorf("Error decoding Config-Map: %v", err)
+		return err
+	}
+
+	var stale []string
+	if options.Prune {
+		stale, err = c.staleConfigMapKeys(context, configName, data.values(), options)
+		if err != nil {
+			log.Errorf("Error pruning Config-Map: %v", err)
+			return err
+		}
+	}
+
+	var createdConfigMap = &corev1.ConfigMap{}
 
 	if c.commitMode == CREATE {
-		createdConfigMap, err = c.createConfigMap(context, configName, configData, log)
+		createdConfigMap, err = c.createConfigMap(context, configName, data, options, log)
 	} else if c.commitMode == APPLY {
-		createdConfigMap, err = c.applyConfigMap(context, configName, configData, log)
+		createdConfigMap, err = c.applyConfigMap(context, configName, data, options, log)
 	}
 
 	if err != nil {
@@ -125,24 +226,26 @@ func (c kubernetesClient) ApplyConfigMap(context context.Context, configName str
 		log.Errorf("Error applying Config-Map: %v", err)
 		return err
 	}
+
+	if options.Prune {
+		err = c.pruneConfigMap(context, configName, stale, log)
+		if err != nil {
+			log.Errorf("Error pruning Config-Map: %v", err)
+			return err
+		}
+		log.WithField("pruned", stale).Infof("Pruned %d keys from config-map %s", len(stale), configName)
+	}
 	return nil
 }
 
-func (c kubernetesClient) createSecret(context context.Context, secretName string, secretData map[string]string, log *logrus.Logger) (*corev1.Secret, error) {
-	secret := corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      secretName,
-			Namespace: c.config.namespace,
-			Labels: map[string]string{
-				"app.kubernetes.io/update-by": "k8s-from-secrets-vault",
-			},
-		},
-		StringData: secretData,
-		Type:       "Opaque",
-	}
+func (c kubernetesClient) createSecret(context context.Context, secretName string, secretData objectData, options ApplyOptions, log *logrus.Logger) (*corev1.Secret, error) {
+	secret := c.newSecret(secretName, secretData, options)
 
 	log.Infof("Creating secret %s in namespace %s", secretName, c.config.namespace)
-	createdSecret, err := c.client.CoreV1().Secrets(c.config.namespace).Create(context, &secret, metav1.CreateOptions{FieldManager: fieldManagerName})
+	createdSecret, err := c.client.CoreV1().Secrets(c.config.namespace).Create(context, secret, metav1.CreateOptions{FieldManager: fieldManagerName})
+	if errors.IsAlreadyExists(err) {
+		createdSecret, err = c.mergeSecret(context, secret, log)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -151,24 +254,35 @@ func (c kubernetesClient) createSecret(context context.Context, secretName strin
 	return createdSecret, err
 }
 
-func (c kubernetesClient) applySecret(context context.Context, secretName string, secretData map[string]string, log *logrus.Logger) (*corev1.Secret, error) {
-	secret := applyv1.Secret(secretName, c.config.namespace)
-	secret = secret.WithType("Opaque")
-	secret = secret.WithStringData(secretData)
-	secret = secret.WithAnnotations(map[string]string{
-		"app.kubernetes.io/update-by": "k8s-from-secrets-vault",
-	})
+// mergeSecret merges the data and metadata into an existing secret, leaving the other keys in place like apply does
+func (c kubernetesClient) mergeSecret(context context.Context, secret *corev1.Secret, log *logrus.Logger) (*corev1.Secret, error) {
+	fields := map[string]interface{}{
+		"metadata":   map[string]interface{}{"labels": secret.Labels, "annotations": secret.Annotations},
+		"stringData": secret.StringData,
+	}
+	// A null field would delete every existing key, so data is only patched when there are binary values
+	if len(secret.Data) > 0 {
+		fields["data"] = secret.Data
+	}
+	patch, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
 
-	log.Infof("(Dry Run) Applying secret %s in namespace %s", secretName, c.config.namespace)
-	appliedSecret, err := c.client.CoreV1().Secrets(c.config.namespace).Apply(context, secret, metav1.ApplyOptions{DryRun: []string{"All"}, FieldManager: fieldManagerName})
+	log.Infof("Updating existing secret %s in namespace %s", secret.Name, c.config.namespace)
+	return c.client.CoreV1().Secrets(c.config.namespace).Patch(context, secret.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManagerName})
+}
+
+func (c kubernetesClient) applySecret(context context.Context, secretName string, secretData objectData, options ApplyOptions, log *logrus.Logger) (*corev1.Secret, error) {
+	secret := c.secretApplyConfiguration(secretName, secretData, options)
 
+	_, err := c.dryRunApplySecret(context, secret, log)
 	if err != nil {
-		log.Errorf("(Dry run) Error applying secret: %v", err)
 		return nil, err
 	}
 
 	log.Infof("Applying secret %s in namespace %s", secretName, c.config.namespace)
-	appliedSecret, err = c.client.CoreV1().Secrets(c.config.namespace).Apply(context, secret, metav1.ApplyOptions{FieldManager: fieldManagerName})
+	appliedSecret, err := c.client.CoreV1().Secrets(c.config.namespace).Apply(context, secret, metav1.ApplyOptions{FieldManager: fieldManagerName})
 	if err != nil {
 		return nil, err
 	}
@@ -177,20 +291,52 @@ func (c kubernetesClient) applySecret(context context.Context, secretName string
 	return appliedSecret, err
 }
 
-func (c kubernetesClient) createConfigMap(context context.Context, configName string, configData map[string]string, log *logrus.Logger) (*corev1.ConfigMap, error) {
-	configmap := corev1.ConfigMap{
+func (c kubernetesClient) newSecret(secretName string, secretData objectData, options ApplyOptions) *corev1.Secret {
+	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      configName,
-			Namespace: c.config.namespace,
-			Labels: map[string]string{
-				"app.kubernetes.io/update-by": "k8s-from-secrets-vault",
-			},
+			Name:        secretName,
+			Namespace:   c.config.namespace,
+			Labels:      objectLabels(options),
+			Annotations: options.Annotations,
 		},
-		Data: configData,
+		StringData: secretData.text,
+		Data:       secretData.binaryOrNil(),
+		Type:       corev1.SecretType(options.secretType()),
 	}
+}
+
+func (c kubernetesClient) secretApplyConfiguration(secretName string, secretData objectData, options ApplyOptions) *applyv1.SecretApplyConfiguration {
+	secret := applyv1.Secret(secretName, c.config.namespace)
+	secret = secret.WithType(corev1.SecretType(options.secretType()))
+	secret = secret.WithStringData(secretData.text)
+	secret = secret.WithData(secretData.binaryOrNil())
+	secret = secret.WithAnnotations(map[string]string{
+		updatedByAnnotation: fieldManagerName,
+	})
+	secret = secret.WithAnnotations(options.Annotations)
+	secret = secret.WithLabels(options.Labels)
+	return secret
+}
+
+// dryRunApplySecret returns the secret the API server would store, without persisting it
+func (c kubernetesClient) dryRunApplySecret(context context.Context, secret *applyv1.SecretApplyConfiguration, log *logrus.Logger) (*corev1.Secret, error) {
+	log.Infof("(Dry Run) Applying secret %s in namespace %s", *secret.Name, c.config.namespace)
+	appliedSecret, err := c.client.CoreV1().Secrets(c.config.namespace).Apply(context, secret, metav1.ApplyOptions{DryRun: []string{"All"}, FieldManager: fieldManagerName})
+	if err != nil {
+		log.Errorf("(Dry run) Error applying secret: %v", err)
+		return nil, err
+	}
+	return appliedSecret, nil
+}
+
+func (c kubernetesClient) createConfigMap(context context.Context, configName string, configData objectData, options ApplyOptions, log *logrus.Logger) (*corev1.ConfigMap, error) {
+	configmap := c.newConfigMap(configName, configData, options)
 
 	log.Infof("Creating config-map %s in namespace %s", configName, c.config.namespace)
-	createdConfigMap, err := c.client.CoreV1().ConfigMaps(c.config.namespace).Create(context, &configmap, metav1.CreateOptions{FieldManager: fieldManagerName})
+	createdConfigMap, err := c.client.CoreV1().ConfigMaps(c.config.namespace).Create(context, configmap, metav1.CreateOptions{FieldManager: fieldManagerName})
+	if errors.IsAlreadyExists(err) {
+		createdConfigMap, err = c.mergeConfigMap(context, configmap, log)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -199,23 +345,34 @@ func (c kubernetesClient) createConfigMap(context context.Context, configName st
 	return createdConfigMap, err
 }
 
-func (c kubernetesClient) applyConfigMap(context context.Context, configName string, configData map[string]string, log *logrus.Logger) (*corev1.ConfigMap, error) {
-	configmap := applyv1.ConfigMap(configName, c.config.namespace)
-	configmap = configmap.WithData(configData)
-	configmap = configmap.WithAnnotations(map[string]string{
-		"app.kubernetes.io/update-by": "k8s-from-secrets-vault",
-	})
+// mergeConfigMap merges the data and metadata into an existing config-map, leaving the other keys in place like apply does
+func (c kubernetesClient) mergeConfigMap(context context.Context, configmap *corev1.ConfigMap, log *logrus.Logger) (*corev1.ConfigMap, error) {
+	fields := map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": configmap.Labels, "annotations": configmap.Annotations},
+		"data":     configmap.Data,
+	}
+	if len(configmap.BinaryData) > 0 {
+		fields["binaryData"] = configmap.BinaryData
+	}
+	patch, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
 
-	log.Infof("(Dry Run) Applying config-map %s in namespace %s", configName, c.config.namespace)
-	appliedConfigMap, err := c.client.CoreV1().ConfigMaps(c.config.namespace).Apply(context, configmap, metav1.ApplyOptions{DryRun: []string{"All"}, FieldManager: fieldManagerName})
+	log.Infof("Updating existing config-map %s in namespace %s", configmap.Name, c.config.namespace)
+	return c.client.CoreV1().ConfigMaps(c.config.namespace).Patch(context, configmap.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManagerName})
+}
+
+func (c kubernetesClient) applyConfigMap(context context.Context, configName string, configData objectData, options ApplyOptions, log *logrus.Logger) (*corev1.ConfigMap, error) {
+	configmap := c.configMapApplyConfiguration(configName, configData, options)
 
+	_, err := c.dryRunApplyConfigMap(context, configmap, log)
 	if err != nil {
-		log.Errorf("(Dry run) Error applying config-map: %v", err)
 		return nil, err
 	}
 
 	log.Infof("Applying config-map %s in namespace %s", configName, c.config.namespace)
-	appliedConfigMap, err = c.client.CoreV1().ConfigMaps(c.config.namespace).Apply(context, configmap, metav1.ApplyOptions{FieldManager: fieldManagerName})
+	appliedConfigMap, err := c.client.CoreV1().ConfigMaps(c.config.namespace).Apply(context, configmap, metav1.ApplyOptions{FieldManager: fieldManagerName})
 	if err != nil {
 		return nil, err
 	}
@@ -223,3 +380,49 @@ func (c kubernetesClient) applyConfigMap(context context.Context, configName str
 
 	return appliedConfigMap, err
 }
+
+func (c kubernetesClient) newConfigMap(configName string, configData objectData, options ApplyOptions) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        configName,
+			Namespace:   c.config.namespace,
+			Labels:      objectLabels(options),
+			Annotations: options.Annotations,
+		},
+		Data:       configData.text,
+		BinaryData: configData.binaryOrNil(),
+	}
+}
+
+func (c kubernetesClient) configMapApplyConfiguration(configName string, configData objectData, options ApplyOptions) *applyv1.ConfigMapApplyConfiguration {
+	configmap := applyv1.ConfigMap(configName, c.config.namespace)
+	configmap = configmap.WithData(configData.text)
+	configmap = configmap.WithBinaryData(configData.binaryOrNil())
+	configmap = configmap.WithAnnotations(map[string]string{
+		updatedByAnnotation: fieldManagerName,
+	})
+	configmap = configmap.WithAnnotations(options.Annotations)
+	configmap = configmap.WithLabels(options.Labels)
+	return configmap
+}
+
+// dryRunApplyConfigMap returns the config-map the API server would store, without persisting it
+func (c kubernetesClient) dryRunApplyConfigMap(context context.Context, configmap *applyv1.ConfigMapApplyConfiguration, log *logrus.Logger) (*corev1.ConfigMap, error) {
+	log.Infof("(Dry Run) Applying config-map %s in namespace %s", *configmap.Name, c.config.namespace)
+	appliedConfigMap, err := c.client.CoreV1().ConfigMaps(c.config.namespace).Apply(context, configmap, metav1.ApplyOptions{DryRun: []string{"All"}, FieldManager: fieldManagerName})
+	if err != nil {
+		log.Errorf("(Dry run) Error applying config-map: %v", err)
+		return nil, err
+	}
+	return appliedConfigMap, nil
+}
+
+func objectLabels(options ApplyOptions) map[string]string {
+	labels := map[string]string{
+		updatedByAnnotation: fieldManagerName,
+	}
+	for key, value := range options.Labels {
+		labels[key] = value
+	}
+	return labels
+}
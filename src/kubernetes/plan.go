@@ -0,0 +1,236 @@
+package kubernetes_client
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+	"strings"
+)
+
+// Plan lists the key level changes applying an object would make, values are never included
+type Plan struct {
+	Kind        string
+	Namespace   string
+	Name        string
+	Create      bool
+	Data        KeyChanges
+	Labels      KeyChanges
+	Annotations KeyChanges
+}
+
+// KeyChanges holds the sorted keys of a map that would be added, changed or removed
+type KeyChanges struct {
+	Added   []string
+	Changed []string
+	Removed []string
+}
+
+func (changes KeyChanges) HasChanges() bool {
+	return len(changes.Added) > 0 || len(changes.Changed) > 0 || len(changes.Removed) > 0
+}
+
+func (plan Plan) HasChanges() bool {
+	return plan.Create || plan.Data.HasChanges() || plan.Labels.HasChanges() || plan.Annotations.HasChanges()
+}
+
+func (plan Plan) String() string {
+	var builder strings.Builder
+
+	action := "~"
+	if plan.Create {
+		action = "+"
+	} else if !plan.HasChanges() {
+		action = "="
+	}
+	builder.WriteString(fmt.Sprintf("%s %s %s/%s\n", action, plan.Kind, plan.Namespace, plan.Name))
+
+	writeKeyChanges(&builder, "", plan.Data)
+	writeKeyChanges(&builder, "label ", plan.Labels)
+	writeKeyChanges(&builder, "annotation ", plan.Annotations)
+
+	return builder.String()
+}
+
+func writeKeyChanges(builder *strings.Builder, prefix string, changes KeyChanges) {
+	for _, key := range changes.Added {
+		builder.WriteString(fmt.Sprintf("    + %s%s\n", prefix, key))
+	}
+	for _, key := range changes.Changed {
+		builder.WriteString(fmt.Sprintf("    ~ %s%s\n", prefix, key))
+	}
+	for _, key := range changes.Removed {
+		builder.WriteString(fmt.Sprintf("    - %s%s\n", prefix, key))
+	}
+}
+
+func (c kubernetesClient) PlanSecret(context context.Context, secretName string, secretData map[string]string, options ApplyOptions, log *logrus.Logger) (Plan, error) {
+	plan := Plan{Kind: "Secret", Namespace: c.config.namespace, Name: secretName}
+
+	secretData, err := typedSecretData(secretData, options)
+	if err != nil {
+		log.Errorf("Error validating secret: %v", err)
+		return Plan{}, err
+	}
+	data, err := splitBinaryData(secretData, options)
+	if err != nil {
+		log.Errorf("Error decoding secret: %v", err)
+		return Plan{}, err
+	}
+
+	log.Infof("Planning secret %s in namespace %s", secretName, c.config.namespace)
+	liveSecret, err := c.client.CoreV1().Secrets(c.config.namespace).Get(context, secretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		liveSecret, plan.Create = &corev1.Secret{}, true
+	} else if err != nil {
+		log.Errorf("Error reading secret: %v", err)
+		return Plan{}, err
+	}
+
+	if options.Prune {
+		// Refuse the same keys the apply would refuse before the dry-run apply, so the plan never reports removals that cannot happen
+		stale := staleKeys(secretStringData(liveSecret), data.values())
+		err = checkPruneOwnership(liveSecret.ManagedFields, []string{"f:data", "f:stringData"}, stale, options)
+		if err != nil {
+			log.Errorf("Error planning secret prune: %v", err)
+			return Plan{}, err
+		}
+	}
+
+	var desiredSecret *corev1.Secret
+	if c.commitMode == APPLY {
+		desiredSecret, err = c.dryRunApplySecret(context, c.secretApplyConfiguration(secretName, data, options), log)
+		if err != nil {
+			return Plan{}, err
+		}
+	} else {
+		desiredSecret = c.newSecret(secretName, data, options)
+		desiredSecret.StringData = mergeKeys(secretStringData(liveSecret), data.values())
+		desiredSecret.Data = nil
+		desiredSecret.Labels = mergeKeys(liveSecret.Labels, desiredSecret.Labels)
+		desiredSecret.Annotations = mergeKeys(liveSecret.Annotations, desiredSecret.Annotations)
+	}
+
+	desiredData := secretStringData(desiredSecret)
+	if options.Prune {
+		desiredData = withoutStaleKeys(desiredData, data.values())
+	}
+
+	plan.Data = diffKeys(secretStringData(liveSecret), desiredData)
+	plan.Labels = diffKeys(liveSecret.Labels, desiredSecret.Labels)
+	plan.Annotations = diffKeys(liveSecret.Annotations, desiredSecret.Annotations)
+	return plan, nil
+}
+
+func (c kubernetesClient) PlanConfigMap(context context.Context, configName string, configData map[string]string, options ApplyOptions, log *logrus.Logger) (Plan, error) {
+	plan := Plan{Kind: "ConfigMap", Namespace: c.config.namespace, Name: configName}
+
+	data, err := splitBinaryData(configData, options)
+	if err != nil {
+		log.Errorf("Error decoding config-map: %v", err)
+		return Plan{}, err
+	}
+
+	log.Infof("Planning config-map %s in namespace %s", configName, c.config.namespace)
+	liveConfigMap, err := c.client.CoreV1().ConfigMaps(c.config.namespace).Get(context, configName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		liveConfigMap, plan.Create = &corev1.ConfigMap{}, true
+	} else if err != nil {
+		log.Errorf("Error reading config-map: %v", err)
+		return Plan{}, err
+	}
+
+	if options.Prune {
+		// Refuse the same keys the apply would refuse before the dry-run apply, so the plan never reports removals that cannot happen
+		stale := staleKeys(configMapData(liveConfigMap), data.values())
+		err = checkPruneOwnership(liveConfigMap.ManagedFields, []string{"f:data", "f:binaryData"}, stale, options)
+		if err != nil {
+			log.Errorf("Error planning config-map prune: %v", err)
+			return Plan{}, err
+		}
+	}
+
+	var desiredConfigMap *corev1.ConfigMap
+	if c.commitMode == APPLY {
+		desiredConfigMap, err = c.dryRunApplyConfigMap(context, c.configMapApplyConfiguration(configName, data, options), log)
+		if err != nil {
+			return Plan{}, err
+		}
+	} else {
+		desiredConfigMap = c.newConfigMap(configName, data, options)
+		desiredConfigMap.Data = mergeKeys(configMapData(liveConfigMap), data.values())
+		desiredConfigMap.BinaryData = nil
+		desiredConfigMap.Labels = mergeKeys(liveConfigMap.Labels, desiredConfigMap.Labels)
+		desiredConfigMap.Annotations = mergeKeys(liveConfigMap.Annotations, desiredConfigMap.Annotations)
+	}
+
+	desiredData := configMapData(desiredConfigMap)
+	if options.Prune {
+		desiredData = withoutStaleKeys(desiredData, data.values())
+	}
+
+	plan.Data = diffKeys(configMapData(liveConfigMap), desiredData)
+	plan.Labels = diffKeys(liveConfigMap.Labels, desiredConfigMap.Labels)
+	plan.Annotations = diffKeys(liveConfigMap.Annotations, desiredConfigMap.Annotations)
+	return plan, nil
+}
+
+// secretStringData decodes the secret data, including string data not yet converted by the API server
+func secretStringData(secret *corev1.Secret) map[string]string {
+	data := make(map[string]string, len(secret.Data)+len(secret.StringData))
+	for key, value := range secret.Data {
+		data[key] = string(value)
+	}
+	for key, value := range secret.StringData {
+		data[key] = value
+	}
+	return data
+}
+
+// mergeKeys keeps the live keys not set by this tool, as the API server does on apply
+func mergeKeys(live map[string]string, desired map[string]string) map[string]string {
+	merged := make(map[string]string, len(live)+len(desired))
+	for key, value := range live {
+		merged[key] = value
+	}
+	for key, value := range desired {
+		merged[key] = value
+	}
+	return merged
+}
+
+// withoutStaleKeys drops the keys pruning would remove
+func withoutStaleKeys(data map[string]string, vaultData map[string]string) map[string]string {
+	kept := make(map[string]string, len(vaultData))
+	for key, value := range data {
+		if _, exists := vaultData[key]; exists {
+			kept[key] = value
+		}
+	}
+	return kept
+}
+
+func diffKeys(live map[string]string, desired map[string]string) KeyChanges {
+	var changes KeyChanges
+	for key, value := range desired {
+		liveValue, exists := live[key]
+		if !exists {
+			changes.Added = append(changes.Added, key)
+		} else if liveValue != value {
+			changes.Changed = append(changes.Changed, key)
+		}
+	}
+	for key := range live {
+		if _, exists := desired[key]; !exists {
+			changes.Removed = append(changes.Removed, key)
+		}
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Changed)
+	sort.Strings(changes.Removed)
+	return changes
+}
@@ -0,0 +1,151 @@
+package kubernetes_client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sort"
+	"strings"
+)
+
+// staleSecretKeys returns the secret keys missing from the Vault data, refusing keys other field managers own before anything is written
+func (c kubernetesClient) staleSecretKeys(context context.Context, secretName string, secretData map[string]string, options ApplyOptions) ([]string, error) {
+	liveSecret, err := c.client.CoreV1().Secrets(c.config.namespace).Get(context, secretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stale := staleKeys(secretStringData(liveSecret), secretData)
+	err = checkPruneOwnership(liveSecret.ManagedFields, []string{"f:data", "f:stringData"}, stale, options)
+	if err != nil {
+		return nil, err
+	}
+	return stale, nil
+}
+
+// pruneSecret removes the stale keys from the secret
+func (c kubernetesClient) pruneSecret(context context.Context, secretName string, stale []string, log *logrus.Logger) error {
+	if len(stale) == 0 {
+		return nil
+	}
+
+	patch, err := removeKeysPatch(stale, "data", "stringData")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Pruning keys %s from secret %s in namespace %s", strings.Join(stale, ", "), secretName, c.config.namespace)
+	_, err = c.client.CoreV1().Secrets(c.config.namespace).Patch(context, secretName, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManagerName})
+	return err
+}
+
+// staleConfigMapKeys returns the config-map keys missing from the Vault data, refusing keys other field managers own before anything is written
+func (c kubernetesClient) staleConfigMapKeys(context context.Context, configName string, configData map[string]string, options ApplyOptions) ([]string, error) {
+	liveConfigMap, err := c.client.CoreV1().ConfigMaps(c.config.namespace).Get(context, configName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stale := staleKeys(configMapData(liveConfigMap), configData)
+	err = checkPruneOwnership(liveConfigMap.ManagedFields, []string{"f:data", "f:binaryData"}, stale, options)
+	if err != nil {
+		return nil, err
+	}
+	return stale, nil
+}
+
+// pruneConfigMap removes the stale keys from the config-map
+func (c kubernetesClient) pruneConfigMap(context context.Context, configName string, stale []string, log *logrus.Logger) error {
+	if len(stale) == 0 {
+		return nil
+	}
+
+	patch, err := removeKeysPatch(stale, "data", "binaryData")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Pruning keys %s from config-map %s in namespace %s", strings.Join(stale, ", "), configName, c.config.namespace)
+	_, err = c.client.CoreV1().ConfigMaps(c.config.namespace).Patch(context, configName, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManagerName})
+	return err
+}
+
+func staleKeys(live map[string]string, desired map[string]string) []string {
+	var stale []string
+	for key := range live {
+		if _, exists := desired[key]; !exists {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// checkPruneOwnership refuses to prune keys another field manager owns, unless pruning is forced
+func checkPruneOwnership(managedFields []metav1.ManagedFieldsEntry, fields []string, keys []string, options ApplyOptions) error {
+	if options.ForcePrune {
+		return nil
+	}
+
+	var foreign []string
+	for _, key := range keys {
+		managers, err := foreignManagers(managedFields, fields, key)
+		if err != nil {
+			return err
+		}
+		if len(managers) > 0 {
+			foreign = append(foreign, fmt.Sprintf("%s (%s)", key, strings.Join(managers, ", ")))
+		}
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("refusing to prune keys owned by other field managers: %s", strings.Join(foreign, "; "))
+	}
+	return nil
+}
+
+// foreignManagers lists the field managers other than this tool owning the key in any of the given fields, failing when an entry cannot be read
+func foreignManagers(managedFields []metav1.ManagedFieldsEntry, fields []string, key string) ([]string, error) {
+	var managers []string
+	for _, entry := range managedFields {
+		if entry.Manager == fieldManagerName || entry.FieldsV1 == nil {
+			continue
+		}
+
+		var owned map[string]map[string]json.RawMessage
+		err := json.Unmarshal(entry.FieldsV1.Raw, &owned)
+		if err != nil {
+			return nil, fmt.Errorf("reading the fields managed by %s: %v", entry.Manager, err)
+		}
+		for _, field := range fields {
+			if _, exists := owned[field]["f:"+key]; exists {
+				managers = append(managers, entry.Manager)
+				break
+			}
+		}
+	}
+	return managers, nil
+}
+
+// removeKeysPatch builds a merge patch deleting the keys from every given field
+func removeKeysPatch(keys []string, fields ...string) ([]byte, error) {
+	removed := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		removed[key] = nil
+	}
+
+	patch := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		patch[field] = removed
+	}
+	return json.Marshal(patch)
+}
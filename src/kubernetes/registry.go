@@ -0,0 +1,102 @@
+package kubernetes_client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// RegistryCredentials are the credentials of one container registry in a dockerconfigjson secret
+type RegistryCredentials struct {
+	Server   string
+	Username string
+	Password string
+	Email    string
+}
+
+// RegistryKeys names the Vault keys holding each registry credential
+type RegistryKeys struct {
+	Server   string
+	Username string
+	Password string
+	Email    string
+}
+
+const (
+	RegistryServerKey   = "server"
+	RegistryUsernameKey = "username"
+	RegistryPasswordKey = "password"
+	RegistryEmailKey    = "email"
+)
+
+// NewRegistryKeys maps the default registry key names through the given mapping of default key to Vault key
+func NewRegistryKeys(mapping map[string]string) RegistryKeys {
+	key := func(name string) string {
+		if vaultKey, ok := mapping[name]; ok {
+			return vaultKey
+		}
+		return name
+	}
+	return RegistryKeys{
+		Server:   key(RegistryServerKey),
+		Username: key(RegistryUsernameKey),
+		Password: key(RegistryPasswordKey),
+		Email:    key(RegistryEmailKey),
+	}
+}
+
+func IsRegistryKey(key string) bool {
+	return key == RegistryServerKey || key == RegistryUsernameKey || key == RegistryPasswordKey || key == RegistryEmailKey
+}
+
+// Credentials reads the registry credentials from Vault secret data, the email is optional
+func (keys RegistryKeys) Credentials(data map[string]string) (RegistryCredentials, error) {
+	credentials := RegistryCredentials{
+		Server:   data[keys.Server],
+		Username: data[keys.Username],
+		Password: data[keys.Password],
+		Email:    data[keys.Email],
+	}
+
+	for _, key := range []string{keys.Server, keys.Username, keys.Password} {
+		if data[key] == "" {
+			return RegistryCredentials{}, fmt.Errorf("registry key %s not found in vault secret", key)
+		}
+	}
+	return credentials, nil
+}
+
+type dockerConfigJson struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+type dockerConfigEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email,omitempty"`
+	Auth     string `json:"auth"`
+}
+
+// DockerConfigJsonData builds the data of a kubernetes.io/dockerconfigjson secret holding every registry
+func DockerConfigJsonData(registries []RegistryCredentials) (map[string]string, error) {
+	config := dockerConfigJson{Auths: make(map[string]dockerConfigEntry, len(registries))}
+
+	for _, registry := range registries {
+		if _, exists := config.Auths[registry.Server]; exists {
+			return nil, fmt.Errorf("registry %s is defined more than once", registry.Server)
+		}
+		config.Auths[registry.Server] = dockerConfigEntry{
+			Username: registry.Username,
+			Password: registry.Password,
+			Email:    registry.Email,
+			Auth:     base64.StdEncoding.EncodeToString([]byte(registry.Username + ":" + registry.Password)),
+		}
+	}
+
+	content, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{corev1.DockerConfigJsonKey: string(content)}, nil
+}
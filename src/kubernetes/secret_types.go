@@ -0,0 +1,139 @@
+package kubernetes_client
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"sort"
+	"strings"
+)
+
+const (
+	SecretTypeOpaque           = string(corev1.SecretTypeOpaque)
+	SecretTypeTls              = string(corev1.SecretTypeTLS)
+	SecretTypeDockerConfigJson = string(corev1.SecretTypeDockerConfigJson)
+	SecretTypeBasicAuth        = string(corev1.SecretTypeBasicAuth)
+	SecretTypeSshAuth          = string(corev1.SecretTypeSSHAuth)
+)
+
+// secretTypeKeys lists the keys each supported secret type can hold, Opaque accepts any key
+var secretTypeKeys = map[string][]string{
+	SecretTypeOpaque:           nil,
+	SecretTypeTls:              {corev1.TLSCertKey, corev1.TLSPrivateKeyKey},
+	SecretTypeDockerConfigJson: {corev1.DockerConfigJsonKey},
+	SecretTypeBasicAuth:        {corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey},
+	SecretTypeSshAuth:          {corev1.SSHAuthPrivateKey},
+}
+
+func IsSecretType(secretType string) bool {
+	_, ok := secretTypeKeys[secretType]
+	return ok
+}
+
+// SecretTypes returns the supported secret types, sorted
+func SecretTypes() []string {
+	types := make([]string, 0, len(secretTypeKeys))
+	for secretType := range secretTypeKeys {
+		types = append(types, secretType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// IsSecretTypeKey reports whether the key is one the secret type defines
+func IsSecretTypeKey(secretType string, key string) bool {
+	for _, typeKey := range secretTypeKeys[secretType] {
+		if typeKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (options ApplyOptions) secretType() string {
+	if options.SecretType == "" {
+		return SecretTypeOpaque
+	}
+	return options.SecretType
+}
+
+// typedSecretData renames the mapped Vault keys to the secret type keys and checks the result is valid for the type
+func typedSecretData(secretData map[string]string, options ApplyOptions) (map[string]string, error) {
+	secretType := options.secretType()
+	if !IsSecretType(secretType) {
+		return nil, fmt.Errorf("secret type %s is not supported, use one of %s", secretType, strings.Join(SecretTypes(), ", "))
+	}
+
+	data := make(map[string]string, len(secretData))
+	for key, value := range secretData {
+		data[key] = value
+	}
+	for typeKey, vaultKey := range options.SecretKeys {
+		value, ok := secretData[vaultKey]
+		if !ok {
+			return nil, fmt.Errorf("key %s mapped to %s not found in vault secret", vaultKey, typeKey)
+		}
+		delete(data, vaultKey)
+		data[typeKey] = value
+	}
+
+	err := validateSecretData(secretType, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s secret: %w", secretType, err)
+	}
+	return data, nil
+}
+
+func validateSecretData(secretType string, data map[string]string) error {
+	switch secretType {
+	case SecretTypeTls:
+		err := requireKeys(data, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+		if err != nil {
+			return err
+		}
+		_, err = tls.X509KeyPair([]byte(data[corev1.TLSCertKey]), []byte(data[corev1.TLSPrivateKeyKey]))
+		if err != nil {
+			return fmt.Errorf("%s and %s are not a valid PEM certificate and key pair: %w", corev1.TLSCertKey, corev1.TLSPrivateKeyKey, err)
+		}
+	case SecretTypeDockerConfigJson:
+		err := requireKeys(data, corev1.DockerConfigJsonKey)
+		if err != nil {
+			return err
+		}
+		var config struct {
+			Auths map[string]json.RawMessage `json:"auths"`
+		}
+		err = json.Unmarshal([]byte(data[corev1.DockerConfigJsonKey]), &config)
+		if err != nil {
+			return fmt.Errorf("%s is not valid JSON: %w", corev1.DockerConfigJsonKey, err)
+		}
+		if len(config.Auths) == 0 {
+			return fmt.Errorf("%s has no auths entries", corev1.DockerConfigJsonKey)
+		}
+	case SecretTypeBasicAuth:
+		if data[corev1.BasicAuthUsernameKey] == "" && data[corev1.BasicAuthPasswordKey] == "" {
+			return fmt.Errorf("%s or %s is required", corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey)
+		}
+	case SecretTypeSshAuth:
+		err := requireKeys(data, corev1.SSHAuthPrivateKey)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode([]byte(data[corev1.SSHAuthPrivateKey]))
+		if block == nil {
+			return fmt.Errorf("%s is not a PEM encoded private key", corev1.SSHAuthPrivateKey)
+		}
+	}
+	return nil
+}
+
+func requireKeys(data map[string]string, keys ...string) error {
+	for _, key := range keys {
+		if data[key] == "" {
+			return fmt.Errorf("%s is required", key)
+		}
+	}
+	return nil
+}
@@ -5,17 +5,9 @@ import (
 	"os"
 )
 
-func main() {
-	command, err := app.SetupCommand()
-	if err != nil {
-		os.Exit(1)
-		return
-	}
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
 
-	err = command.Execute()
-	if err != nil {
-		os.Exit(1)
-		return
-	}
-	os.Exit(0)
+func main() {
+	os.Exit(app.Run(os.Args[1:], version, os.Stdout, os.Stderr))
 }
@@ -519,7 +519,7 @@ func Test_KubernetesClient_GivenKubernetesConfigAndSecretData_CanApplySecret(t *
 		t.Error("Expected no error, got ", err)
 	}
 
-	err = client.ApplySecret(context.Background(), "test-secret", map[string]string{"TEST_KEY": "TEST_VALUE"}, log)
+	err = client.ApplySecret(context.Background(), "test-secret", map[string]string{"TEST_KEY": "TEST_VALUE"}, kubernetes.ApplyOptions{}, log)
 	if err != nil {
 		t.Error("Expected no error, got ", err)
 	}
@@ -551,7 +551,7 @@ func Test_KubernetesClient_GivenKubernetesConfigAndSecretData_CanApplyConfigMap(
 		t.Error("Expected no error, got ", err)
 	}
 
-	err = client.ApplyConfigMap(context.Background(), "test-config", map[string]string{"TEST_KEY": "TEST_VALUE"}, log)
+	err = client.ApplyConfigMap(context.Background(), "test-config", map[string]string{"TEST_KEY": "TEST_VALUE"}, kubernetes.ApplyOptions{}, log)
 	if err != nil {
 		t.Error("Expected no error, got ", err)
 	}
@@ -583,7 +583,7 @@ func Test_KubernetesClient_GivenEmptySecretData_CanApplyEmptySecret(t *testing.T
 		t.Error("Expected no error, got ", err)
 	}
 
-	err = client.ApplySecret(context.Background(), "test-secret", map[string]string{}, log)
+	err = client.ApplySecret(context.Background(), "test-secret", map[string]string{}, kubernetes.ApplyOptions{}, log)
 	if err != nil {
 		t.Error("Expected no error, got ", err)
 	}
@@ -615,7 +615,7 @@ func Test_KubernetesClient_GivenEmptySecretData_CanApplyEmptyConfigMap(t *testin
 		t.Error("Expected no error, got ", err)
 	}
 
-	err = client.ApplyConfigMap(context.Background(), "test-config", map[string]string{}, log)
+	err = client.ApplyConfigMap(context.Background(), "test-config", map[string]string{}, kubernetes.ApplyOptions{}, log)
 	if err != nil {
 		t.Error("Expected no error, got ", err)
 	}
@@ -661,3 +661,57 @@ func Test_KubernetesClient_GivenValidParameters_CanCreateConfig(t *testing.T) {
 		t.Error("Expected server to be 'https://example.com'")
 	}
 }
+
+func Test_Command_GivenSecretVersion_AnnotatesAppliedVersion(t *testing.T) {
+	log := setupLogger(t)
+
+	vaultClientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"TEST_KEY": "FIRST"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	writeTestSecret(t, vaultClientConfig, map[string]interface{}{"TEST_KEY": "SECOND"})
+
+	parameters := getFakeKubernetesParameters(t)
+
+	commandArgs := map[string]string{
+		app.VaultAddress:       vaultClientConfig.Address,
+		app.VaultToken:         vaultClientConfig.AuthToken,
+		app.VaultEngine:        vaultClientConfig.EngineName,
+		app.VaultSecretPath:    vaultClientConfig.SecretPath,
+		app.VaultSecretVersion: "1",
+		app.Kubeconfig:         parameters.Base64Kubeconfig,
+		app.Namespace:          parameters.Namespace,
+		app.VaultAuthMethod:    "token",
+		app.ApplyAsConfigmap:   "true",
+		app.ObjectNameToApply:  "test-config",
+	}
+
+	config, err := kubernetes.CreateConfig(parameters, log)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	client, fakeClient, err := getFakeKubernetesClient(config, t)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	command, err := app.SetupCommandWithKubernetesClient(commandArgs, client)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	err = command.Execute()
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	configMap, err := fakeClient.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), "test-config", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	if configMap.Data["TEST_KEY"] != "FIRST" {
+		t.Error("Expected config map to contain TEST_KEY from version 1")
+	}
+	if configMap.Annotations[kubernetes.VaultSecretVersionAnnotation] != "1" {
+		t.Error("Expected config map to be annotated with version 1")
+	}
+}
@@ -2,6 +2,10 @@ package tests
 
 import (
 	"github.com/sirupsen/logrus"
+	"k8s-from-secrets-vault/app"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	vaultclient "k8s-from-secrets-vault/vault"
+	"k8s.io/client-go/kubernetes/fake"
 	"os"
 	"testing"
 )
@@ -17,3 +21,52 @@ func setupLogger(t *testing.T) *logrus.Logger {
 	log.Formatter = &logrus.JSONFormatter{}
 	return log
 }
+
+// setupTestCommand creates a command reading the secret of the Vault config with its token into test-secret on a fake cluster, the extra args add to or override these in order
+func setupTestCommand(t *testing.T, vaultClientConfig vaultclient.VaultConfig, extraArgs ...map[string]string) (*app.Command, *fake.Clientset) {
+	t.Helper()
+	log := setupLogger(t)
+
+	parameters := getFakeKubernetesParameters(t)
+	config, err := kubernetes.CreateConfig(parameters, log)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	client, fakeClient, err := getFakeKubernetesClient(config, t)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	commandArgs := map[string]string{
+		app.VaultAddress:      vaultClientConfig.Address,
+		app.VaultToken:        vaultClientConfig.AuthToken,
+		app.VaultEngine:       vaultClientConfig.EngineName,
+		app.VaultSecretPath:   vaultClientConfig.SecretPath,
+		app.Kubeconfig:        parameters.Base64Kubeconfig,
+		app.Namespace:         parameters.Namespace,
+		app.ObjectNameToApply: "test-secret",
+		app.VaultAuthMethod:   "token",
+	}
+	for _, args := range extraArgs {
+		for key, value := range args {
+			commandArgs[key] = value
+		}
+	}
+
+	command, err := app.SetupCommandWithKubernetesClient(commandArgs, client)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	return command, fakeClient
+}
+
+// setupTestCommandWithSecrets starts a test Vault holding the secrets and creates a command syncing them into test-secret
+func setupTestCommandWithSecrets(t *testing.T, secrets map[string]interface{}, extraArgs map[string]string) (*app.Command, *fake.Clientset) {
+	t.Helper()
+
+	vaultClientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, secrets)
+	t.Cleanup(func() { destroyVaultHttpListener(t, vaultHttpListener) })
+
+	return setupTestCommand(t, vaultClientConfig, extraArgs)
+}
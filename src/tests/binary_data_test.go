@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"k8s-from-secrets-vault/app"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+)
+
+var testKeystore = []byte{0x30, 0x82, 0x0a, 0x00, 0xff, 0xfe, 0x00, 0x01}
+
+func Test_Command_GivenBase64KeySuffix_WritesDecodedValueToSecretData(t *testing.T) {
+	//Arrange
+	command, fakeClient := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"keystore.p12_b64": base64.StdEncoding.EncodeToString(testKeystore),
+		"password":         "changeit",
+	}, map[string]string{
+		app.Base64KeySuffix: "_b64",
+	})
+
+	//Act
+	err := command.Execute()
+
+	//Assert
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	secret, err := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret.Data["keystore.p12"], testKeystore) {
+		t.Errorf("Expected the decoded keystore in secret data, got %v", secret.Data["keystore.p12"])
+	}
+	if secret.StringData["password"] != "changeit" {
+		t.Error("Expected text values to stay in string data")
+	}
+	if _, exists := secret.StringData["keystore.p12_b64"]; exists {
+		t.Error("Expected the encoded value not to be written")
+	}
+}
+
+func Test_Command_GivenBase64KeysForConfigMap_WritesDecodedValueToBinaryData(t *testing.T) {
+	//Arrange
+	encoded := base64.StdEncoding.EncodeToString(testKeystore)
+	command, fakeClient := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"truststore.jks": encoded[:4] + "\n" + encoded[4:],
+		"LOG_LEVEL":      "debug",
+	}, map[string]string{
+		app.ApplyAsConfigmap: "true",
+		app.Base64Keys:       "truststore.jks",
+	})
+
+	//Act
+	err := command.Execute()
+
+	//Assert
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	configMap, err := fakeClient.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(configMap.BinaryData["truststore.jks"], testKeystore) {
+		t.Errorf("Expected the decoded truststore in binary data, got %v", configMap.BinaryData["truststore.jks"])
+	}
+	if configMap.Data["LOG_LEVEL"] != "debug" {
+		t.Error("Expected text values to stay in data")
+	}
+	if _, exists := configMap.Data["truststore.jks"]; exists {
+		t.Error("Expected the binary value to be left out of data")
+	}
+}
+
+func Test_Command_GivenInvalidBase64Value_ReturnsErrorNamingKey(t *testing.T) {
+	//Arrange
+	command, fakeClient := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"keystore.p12_b64": "not base64!",
+	}, map[string]string{
+		app.Base64KeySuffix: "_b64",
+	})
+
+	//Act
+	err := command.Execute()
+
+	//Assert
+	if err == nil || !strings.Contains(err.Error(), "value of key keystore.p12_b64 is not valid base64") {
+		t.Error("Expected invalid base64 error naming the key, got ", err)
+	}
+	if _, getErr := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{}); getErr == nil {
+		t.Error("Expected the secret not to be applied")
+	}
+}
+
+func Test_Command_GivenDecodedKeyCollidingWithTextKey_ReturnsError(t *testing.T) {
+	//Arrange
+	command, _ := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"keystore":     "text",
+		"keystore_b64": base64.StdEncoding.EncodeToString(testKeystore),
+	}, map[string]string{
+		app.Base64KeySuffix: "_b64",
+	})
+
+	//Act
+	err := command.Execute()
+
+	//Assert
+	if err == nil || !strings.Contains(err.Error(), "key keystore is defined both as text and as a base64 value") {
+		t.Error("Expected key collision error, got ", err)
+	}
+}
+
+func Test_Command_GivenAppliedBinaryValue_DiffReportsNoChanges(t *testing.T) {
+	//Arrange
+	command, _ := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"keystore.p12_b64": base64.StdEncoding.EncodeToString(testKeystore),
+	}, map[string]string{
+		app.Base64KeySuffix: "_b64",
+	})
+	err := command.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Act
+	var out bytes.Buffer
+	err = command.Diff(&out)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "= Secret test-namespace/test-secret") {
+		t.Errorf("Expected no pending changes, got %s", out.String())
+	}
+}
@@ -0,0 +1,263 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"k8s-from-secrets-vault/app"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_Run_GivenVersionCommand_PrintsVersion(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	exitCode := app.Run([]string{"version"}, "v1.2.3", &stdout, &stderr)
+
+	if exitCode != app.ExitOk {
+		t.Errorf("Expected exit code %d, got %d", app.ExitOk, exitCode)
+	}
+	if strings.TrimSpace(stdout.String()) != "v1.2.3" {
+		t.Errorf("Expected version v1.2.3, got %s", stdout.String())
+	}
+}
+
+func Test_Run_GivenUnknownCommandOrFlag_ReturnsUsageError(t *testing.T) {
+	for _, args := range [][]string{{"deploy"}, {"sync", "--unknown-flag"}} {
+		var stdout, stderr bytes.Buffer
+
+		exitCode := app.Run(args, "dev", &stdout, &stderr)
+
+		if exitCode != app.ExitUsage {
+			t.Errorf("Expected exit code %d for %v, got %d", app.ExitUsage, args, exitCode)
+		}
+		if !strings.Contains(stderr.String(), "Usage:") {
+			t.Errorf("Expected usage to be printed for %v", args)
+		}
+	}
+}
+
+func Test_Run_GivenHelpFlag_DocumentsEnvironmentVariables(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	exitCode := app.Run([]string{"--help"}, "dev", &stdout, &stderr)
+
+	if exitCode != app.ExitOk {
+		t.Errorf("Expected exit code %d, got %d", app.ExitOk, exitCode)
+	}
+	for _, expected := range []string{"--vault-address", app.VaultAddress, "--kubernetes-namespace", app.Namespace} {
+		if !strings.Contains(stderr.String(), expected) {
+			t.Errorf("Expected help to contain %s", expected)
+		}
+	}
+}
+
+func Test_Run_GivenValidateCommand_FlagsOverrideEnvironment(t *testing.T) {
+	parameters := getFakeKubernetesParameters(t)
+
+	t.Setenv(app.VaultAddress, "http://env-vault:8200")
+	t.Setenv(app.VaultAuthMethod, "token")
+	t.Setenv(app.VaultToken, "")
+	t.Setenv(app.VaultEngine, "secret")
+	t.Setenv(app.VaultSecretPath, "config")
+	t.Setenv(app.Kubeconfig, parameters.Base64Kubeconfig)
+	t.Setenv(app.Namespace, parameters.Namespace)
+	t.Setenv(app.ObjectNameToApply, "config")
+	t.Setenv(app.ApplyAsConfigmap, "false")
+
+	var stdout, stderr bytes.Buffer
+	exitCode := app.Run([]string{"validate"}, "dev", &stdout, &stderr)
+	if exitCode != app.ExitError {
+		t.Errorf("Expected exit code %d without a token, got %d", app.ExitError, exitCode)
+	}
+
+	stdout.Reset()
+	exitCode = app.Run([]string{"validate", "--vault-token", "flag-token", "--load-as-configmap"}, "dev", &stdout, &stderr)
+	if exitCode != app.ExitOk {
+		t.Errorf("Expected exit code %d, got %d", app.ExitOk, exitCode)
+	}
+	if !strings.Contains(stdout.String(), "Configuration is valid") {
+		t.Errorf("Expected validation message, got %s", stdout.String())
+	}
+
+	command, err := app.SetupCommand()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	if command.AuthToken != "flag-token" || !command.LoadAsConfigMap {
+		t.Errorf("Expected flags to override the environment, got token %s and configmap %v", command.AuthToken, command.LoadAsConfigMap)
+	}
+}
+
+func Test_Command_GivenDiff_PrintsKeyChangesWithoutApplying(t *testing.T) {
+	log := setupLogger(t)
+
+	vaultClientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{
+		"KEPT_KEY":    "KEPT_VALUE",
+		"CHANGED_KEY": "NEW_VALUE",
+		"ADDED_KEY":   "ADDED_VALUE",
+	})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	parameters := getFakeKubernetesParameters(t)
+	config, err := kubernetes.CreateConfig(parameters, log)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	client, fakeClient, err := getFakeKubernetesClient(config, t)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	_, err = fakeClient.CoreV1().Secrets("test-namespace").Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"},
+		StringData: map[string]string{
+			"KEPT_KEY":    "KEPT_VALUE",
+			"CHANGED_KEY": "OLD_VALUE",
+			"REMOVED_KEY": "REMOVED_VALUE",
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commandArgs := map[string]string{
+		app.VaultAddress:      vaultClientConfig.Address,
+		app.VaultToken:        vaultClientConfig.AuthToken,
+		app.VaultEngine:       vaultClientConfig.EngineName,
+		app.VaultSecretPath:   vaultClientConfig.SecretPath,
+		app.Kubeconfig:        parameters.Base64Kubeconfig,
+		app.Namespace:         parameters.Namespace,
+		app.ObjectNameToApply: "test-secret",
+		app.VaultAuthMethod:   "token",
+	}
+
+	command, err := app.SetupCommandWithKubernetesClient(commandArgs, client)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	var out bytes.Buffer
+	err = command.Diff(&out)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	expected := "~ Secret test-namespace/test-secret\n    + ADDED_KEY\n    ~ CHANGED_KEY\n" +
+		"    + label app.kubernetes.io/update-by\n    + annotation k8s-from-secrets-vault/vault-secret-version\n"
+	if out.String() != expected {
+		t.Errorf("Expected plan\n%s\ngot\n%s", expected, out.String())
+	}
+	if strings.Contains(out.String(), "VALUE") {
+		t.Error("Expected plan not to contain secret values")
+	}
+
+	secret, err := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.StringData["CHANGED_KEY"] != "OLD_VALUE" {
+		t.Error("Expected diff not to modify the secret")
+	}
+}
+
+func Test_Command_GivenDryRun_ReturnsChangesPendingUntilApplied(t *testing.T) {
+	log := setupLogger(t)
+
+	vaultClientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	parameters := getFakeKubernetesParameters(t)
+	config, err := kubernetes.CreateConfig(parameters, log)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	client, fakeClient, err := getFakeKubernetesClient(config, t)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	commandArgs := map[string]string{
+		app.VaultAddress:      vaultClientConfig.Address,
+		app.VaultToken:        vaultClientConfig.AuthToken,
+		app.VaultEngine:       vaultClientConfig.EngineName,
+		app.VaultSecretPath:   vaultClientConfig.SecretPath,
+		app.Kubeconfig:        parameters.Base64Kubeconfig,
+		app.Namespace:         parameters.Namespace,
+		app.ObjectNameToApply: "test-secret",
+		app.VaultAuthMethod:   "token",
+		app.DryRun:            "true",
+	}
+
+	command, err := app.SetupCommandWithKubernetesClient(commandArgs, client)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	err = command.Execute()
+	if !errors.Is(err, app.ErrChangesPending) {
+		t.Fatal("Expected pending changes, got ", err)
+	}
+	_, err = fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err == nil {
+		t.Fatal("Expected dry run not to create the secret")
+	}
+
+	command.DryRun = false
+	err = command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	command.DryRun = true
+	err = command.Execute()
+	if err != nil {
+		t.Error("Expected no pending changes after applying, got ", err)
+	}
+}
+
+func Test_Command_GivenDryRun_WritesOnlyThePlanToStdout(t *testing.T) {
+	//Arrange
+	vaultClientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	command, _ := setupTestCommand(t, vaultClientConfig, map[string]string{app.DryRun: "true"})
+
+	var plan bytes.Buffer
+	err := command.Diff(&plan)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	//Act
+	err = command.Execute()
+	_ = writer.Close()
+	os.Stdout = stdout
+	output, readErr := io.ReadAll(reader)
+
+	//Assert
+	if !errors.Is(err, app.ErrChangesPending) {
+		t.Fatal("Expected pending changes, got ", err)
+	}
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if string(output) != plan.String() {
+		t.Errorf("Expected stdout to hold only the plan %q, got %q", plan.String(), string(output))
+	}
+}
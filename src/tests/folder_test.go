@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"context"
+	"k8s-from-secrets-vault/app"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func Test_Command_GivenRecursiveFolder_AppliesEverySecretAsItsOwnObject(t *testing.T) {
+	log := setupLogger(t)
+
+	vaultClientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	writeTestSecretToPath(t, vaultClientConfig, vaultClientConfig.EngineName, "dev/database/Primary", map[string]interface{}{"DATABASE_KEY": "DATABASE_VALUE"})
+	writeTestSecretToPath(t, vaultClientConfig, vaultClientConfig.EngineName, "prod/config", map[string]interface{}{"PROD_KEY": "PROD_VALUE"})
+
+	parameters := getFakeKubernetesParameters(t)
+
+	commandArgs := map[string]string{
+		app.VaultAddress:         vaultClientConfig.Address,
+		app.VaultToken:           vaultClientConfig.AuthToken,
+		app.VaultEngine:          vaultClientConfig.EngineName,
+		app.VaultSecretPath:      "dev",
+		app.VaultSecretRecursive: "true",
+		app.Kubeconfig:           parameters.Base64Kubeconfig,
+		app.Namespace:            parameters.Namespace,
+		app.VaultAuthMethod:      "token",
+	}
+
+	config, err := kubernetes.CreateConfig(parameters, log)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	client, fakeClient, err := getFakeKubernetesClient(config, t)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	command, err := app.SetupCommandWithKubernetesClient(commandArgs, client)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	err = command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	expectedSecrets := map[string]map[string]string{
+		"config":           {"CONFIG_KEY": "CONFIG_VALUE"},
+		"database-primary": {"DATABASE_KEY": "DATABASE_VALUE"},
+	}
+	for name, expectedData := range expectedSecrets {
+		secret, err := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("Expected secret %s to be applied, got %v", name, err)
+			continue
+		}
+		for key, value := range expectedData {
+			if secret.StringData[key] != value {
+				t.Errorf("Expected secret %s to contain %s with value %s", name, key, value)
+			}
+		}
+	}
+
+	secrets, err := fakeClient.CoreV1().Secrets("test-namespace").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets.Items) != len(expectedSecrets) {
+		t.Errorf("Expected only secrets from the dev folder, got %d secrets", len(secrets.Items))
+	}
+}
+
+func Test_Command_GivenRecursiveFolderAndNameTemplate_NamesObjectsFromTemplate(t *testing.T) {
+	log := setupLogger(t)
+
+	vaultClientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndKvVersion(t, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"}, "1")
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	parameters := getFakeKubernetesParameters(t)
+
+	commandArgs := map[string]string{
+		app.VaultAddress:         vaultClientConfig.Address,
+		app.VaultToken:           vaultClientConfig.AuthToken,
+		app.VaultEngine:          vaultClientConfig.EngineName,
+		app.VaultSecretPath:      "dev",
+		app.VaultSecretRecursive: "true",
+		app.ObjectNameTemplate:   "my-app-{{ .Name }}",
+		app.ApplyAsConfigmap:     "true",
+		app.Kubeconfig:           parameters.Base64Kubeconfig,
+		app.Namespace:            parameters.Namespace,
+		app.VaultAuthMethod:      "token",
+	}
+
+	config, err := kubernetes.CreateConfig(parameters, log)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	client, fakeClient, err := getFakeKubernetesClient(config, t)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	command, err := app.SetupCommandWithKubernetesClient(commandArgs, client)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	err = command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	configMap, err := fakeClient.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), "my-app-config", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	if configMap.Data["CONFIG_KEY"] != "CONFIG_VALUE" {
+		t.Error("Expected config map to contain CONFIG_KEY with value CONFIG_VALUE")
+	}
+}
+
+func Test_GivenRecursiveSyncWithMultiplePaths_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:         "http://",
+		app.VaultToken:           "test-token",
+		app.VaultEngine:          "test-engine",
+		app.VaultSecretPath:      "dev,prod",
+		app.VaultSecretRecursive: "true",
+		app.Namespace:            "test-namespace",
+		app.Kubeconfig:           "test-kubeconfig",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if err.Error() != "Recursive sync requires a single Vault secret folder" {
+		t.Errorf("Expected error to be 'Recursive sync requires a single Vault secret folder', got '%v'", err)
+	}
+}
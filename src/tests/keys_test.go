@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"k8s-from-secrets-vault/app"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+)
+
+func Test_Command_GivenIncludeAndExcludePatterns_AppliesSelectedKeys(t *testing.T) {
+	//Arrange
+	command, fakeClient := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"DB_USER":     "admin",
+		"DB_PASSWORD": "secret",
+		"DB_DEBUG":    "true",
+		"API_TOKEN":   "token",
+		"OTHER":       "value",
+	}, map[string]string{
+		app.KeyInclude: "DB_*,/^API_/",
+		app.KeyExclude: "*_DEBUG",
+	})
+
+	//Act
+	err := command.Execute()
+
+	//Assert
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	secret, err := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.StringData) != 3 {
+		t.Errorf("Expected 3 keys, got %v", secret.StringData)
+	}
+	for _, key := range []string{"DB_USER", "DB_PASSWORD", "API_TOKEN"} {
+		if _, exists := secret.StringData[key]; !exists {
+			t.Errorf("Expected key %s to be applied", key)
+		}
+	}
+}
+
+func Test_Command_GivenRenamesAndTransforms_AppliesTransformedKeys(t *testing.T) {
+	//Arrange
+	command, fakeClient := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"db_user":  "admin",
+		"password": "secret",
+	}, map[string]string{
+		app.KeyRename: "password:db-password.txt",
+		app.KeyCase:   "upper",
+		app.KeyPrefix: "APP_",
+		app.KeySuffix: "_V1",
+	})
+
+	//Act
+	err := command.Execute()
+
+	//Assert
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	secret, err := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.StringData["APP_DB_USER_V1"] != "admin" {
+		t.Errorf("Expected the transformed key APP_DB_USER_V1, got %v", secret.StringData)
+	}
+	if secret.StringData["db-password.txt"] != "secret" {
+		t.Errorf("Expected the renamed key to skip the transforms, got %v", secret.StringData)
+	}
+}
+
+func Test_Command_GivenTransformsAndBase64Keys_DecodesValuesUnderTransformedKeys(t *testing.T) {
+	//Arrange
+	encoded := base64.StdEncoding.EncodeToString(testKeystore)
+	command, fakeClient := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"keystore_b64": encoded,
+		"truststore":   encoded,
+		"cert_b64":     encoded,
+		"password":     "changeit",
+	}, map[string]string{
+		app.Base64KeySuffix: "_b64",
+		app.Base64Keys:      "truststore",
+		app.KeyRename:       "cert_b64:cert.p12",
+		app.KeyCase:         "upper",
+		app.KeySuffix:       "_V1",
+	})
+
+	//Act
+	err := command.Execute()
+
+	//Assert
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	secret, err := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"KEYSTORE_V1", "TRUSTSTORE_V1", "cert.p12"} {
+		if !bytes.Equal(secret.Data[key], testKeystore) {
+			t.Errorf("Expected the decoded value under %s, got %v", key, secret.Data[key])
+		}
+	}
+	if secret.StringData["PASSWORD_V1"] != "changeit" || len(secret.StringData) != 1 {
+		t.Errorf("Expected only the text value in string data, got %v", secret.StringData)
+	}
+}
+
+func Test_Command_GivenTransformsAndSecretKeyMapping_MapsVaultKeys(t *testing.T) {
+	//Arrange
+	command, fakeClient := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"user": "admin",
+		"pass": "secret",
+	}, map[string]string{
+		app.SecretType:       kubernetes.SecretTypeBasicAuth,
+		app.SecretKeyMapping: "username=user,password=pass",
+		app.KeyPrefix:        "app-",
+	})
+
+	//Act
+	err := command.Execute()
+
+	//Assert
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	secret, err := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.StringData["username"] != "admin" || secret.StringData["password"] != "secret" || len(secret.StringData) != 2 {
+		t.Errorf("Expected the mapped basic auth keys, got %v", secret.StringData)
+	}
+}
+
+func Test_Command_GivenTransformCreatingInvalidKey_ReturnsErrorNamingKey(t *testing.T) {
+	//Arrange
+	command, _ := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"user": "admin",
+	}, map[string]string{
+		app.KeyPrefix: "my prefix/",
+	})
+
+	//Act
+	err := command.Execute()
+
+	//Assert
+	if err == nil || !strings.Contains(err.Error(), `"my prefix/user" from Vault key user`) {
+		t.Fatal("Expected an error naming the invalid key, got ", err)
+	}
+}
+
+func Test_Command_GivenKeysMappingToSameName_ReturnsError(t *testing.T) {
+	//Arrange
+	command, _ := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"user": "admin",
+		"USER": "root",
+	}, map[string]string{
+		app.KeyCase: "lower",
+	})
+
+	//Act
+	err := command.Execute()
+
+	//Assert
+	if err == nil || !strings.Contains(err.Error(), "both map to key user") {
+		t.Fatal("Expected a collision error, got ", err)
+	}
+}
+
+func Test_GivenInvalidKeyRules_ReturnsError(t *testing.T) {
+	cases := map[string]map[string]string{
+		"Key pattern /[/ is not a valid regular expression":  {app.KeyInclude: "/[/"},
+		"Key pattern [ is not a valid glob":                  {app.KeyExclude: "["},
+		"Key renames must be in the form vaultKey:objectKey": {app.KeyRename: "password"},
+		"Key case must be upper or lower":                    {app.KeyCase: "title"},
+	}
+	for expected, args := range cases {
+		//Arrange
+		commandArgs := map[string]string{
+			app.VaultAddress:      "http://",
+			app.VaultToken:        "test-token",
+			app.VaultEngine:       "test-engine",
+			app.VaultSecretPath:   "test-path",
+			app.Namespace:         "test-namespace",
+			app.Kubeconfig:        "test-kubeconfig",
+			app.ObjectNameToApply: "test-secret",
+			app.VaultAuthMethod:   "token",
+		}
+		for key, value := range args {
+			commandArgs[key] = value
+		}
+
+		//Act
+		_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+		//Assert
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+}
@@ -31,6 +31,32 @@ func getFakeKubernetesClient(config kubernetesclient.KubernetesConfig, t *testin
 	return kubernetesclient.InjectKubernetesClient(fakeClient, config), fakeClient, nil
 }
 
+// getTestSecret returns test-secret, the object the test commands apply to
+func getTestSecret(t *testing.T, fakeClient *fake.Clientset) *corev1.Secret {
+	t.Helper()
+
+	secret, err := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// getTestSecretData returns the values of test-secret, whether written as data or as string data
+func getTestSecretData(t *testing.T, fakeClient *fake.Clientset) map[string]string {
+	t.Helper()
+
+	secret := getTestSecret(t, fakeClient)
+	data := map[string]string{}
+	for key, value := range secret.Data {
+		data[key] = string(value)
+	}
+	for key, value := range secret.StringData {
+		data[key] = value
+	}
+	return data
+}
+
 func getFakeKubernetesParameters(t *testing.T) kubernetesclient.KubernetesParameters {
 	t.Helper()
 	return kubernetesclient.KubernetesParameters{
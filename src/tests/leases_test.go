@@ -0,0 +1,289 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"k8s-from-secrets-vault/app"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	vaultclient "k8s-from-secrets-vault/vault"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Command_GivenDatabaseSource_AppliesCredentialsWithLeaseAnnotations(t *testing.T) {
+	fakeVault := createFakeLeaseVault(t, "database/creds/app")
+	command, fakeClient := setupTestCommand(t, vaultclient.VaultConfig{Address: fakeVault.server.URL, AuthToken: fakeVaultClientToken}, map[string]string{
+		app.VaultSourceType:  app.SourceTypeDatabase,
+		app.VaultEngine:      "database",
+		app.VaultSecretPath:  "app",
+		app.SecretKeyMapping: "DB_USER=username,DB_PASSWORD=password",
+	})
+
+	err := command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	secret := getTestSecret(t, fakeClient)
+	if secret.StringData["DB_USER"] != "user-1" || secret.StringData["DB_PASSWORD"] != "password-1" {
+		t.Errorf("Expected mapped credentials, got %v", secret.StringData)
+	}
+	if secret.Annotations[kubernetes.VaultLeaseIdAnnotation] != "database/creds/app/lease-1" {
+		t.Errorf("Expected lease id annotation, got %s", secret.Annotations[kubernetes.VaultLeaseIdAnnotation])
+	}
+	if secret.Annotations[kubernetes.VaultLeaseTtlAnnotation] != "3600" {
+		t.Errorf("Expected lease ttl annotation 3600, got %s", secret.Annotations[kubernetes.VaultLeaseTtlAnnotation])
+	}
+	expiry, err := time.Parse(time.RFC3339, secret.Annotations[kubernetes.VaultLeaseExpiryAnnotation])
+	if err != nil || expiry.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("Expected lease expiry in about an hour, got %s", secret.Annotations[kubernetes.VaultLeaseExpiryAnnotation])
+	}
+	if len(fakeVault.revokedLeases()) != 0 {
+		t.Errorf("Expected no lease to be revoked, got %v", fakeVault.revokedLeases())
+	}
+}
+
+func Test_Command_GivenTokenExpiringBeforeTheLease_RecordsTheTokenExpiry(t *testing.T) {
+	fakeVault := createFakeLeaseVault(t, "database/creds/app")
+	fakeVault.setTokenTtl(600)
+	command, fakeClient := setupTestCommand(t, vaultclient.VaultConfig{Address: fakeVault.server.URL, AuthToken: fakeVaultClientToken}, map[string]string{
+		app.VaultSourceType: app.SourceTypeDatabase,
+		app.VaultEngine:     "database",
+		app.VaultSecretPath: "app",
+	})
+
+	err := command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	secret := getTestSecret(t, fakeClient)
+	if secret.Annotations[kubernetes.VaultLeaseTtlAnnotation] != "600" {
+		t.Errorf("Expected the token ttl 600 as lease ttl annotation, got %s", secret.Annotations[kubernetes.VaultLeaseTtlAnnotation])
+	}
+	expiry, err := time.Parse(time.RFC3339, secret.Annotations[kubernetes.VaultLeaseExpiryAnnotation])
+	if err != nil || expiry.After(time.Now().Add(10*time.Minute)) {
+		t.Errorf("Expected lease expiry at the token expiry in ten minutes, got %s", secret.Annotations[kubernetes.VaultLeaseExpiryAnnotation])
+	}
+}
+
+func Test_Command_GivenRevokePreviousLease_RevokesRecordedLeaseAfterApplying(t *testing.T) {
+	fakeVault := createFakeLeaseVault(t, "aws/creds/deploy")
+	command, fakeClient := setupTestCommand(t, vaultclient.VaultConfig{Address: fakeVault.server.URL, AuthToken: fakeVaultClientToken}, map[string]string{
+		app.VaultSourceType:  app.SourceTypeDynamic,
+		app.VaultEngine:      "aws",
+		app.VaultSecretPath:  "creds/deploy",
+		app.VaultRevokeLease: "true",
+	})
+
+	err := command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	err = command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	secret := getTestSecret(t, fakeClient)
+	if secret.StringData["username"] != "user-2" {
+		t.Errorf("Expected the second credentials, got %v", secret.StringData)
+	}
+	if secret.Annotations[kubernetes.VaultLeaseIdAnnotation] != "aws/creds/deploy/lease-2" {
+		t.Errorf("Expected the second lease to be recorded, got %s", secret.Annotations[kubernetes.VaultLeaseIdAnnotation])
+	}
+
+	revoked := fakeVault.revokedLeases()
+	if len(revoked) != 1 || revoked[0] != "aws/creds/deploy/lease-1" {
+		t.Errorf("Expected only the first lease to be revoked, got %v", revoked)
+	}
+}
+
+func Test_Command_GivenDatabaseSourceWithAppRoleLogin_LeavesTokenOwningTheLease(t *testing.T) {
+	fakeVault := createFakeLeaseVault(t, "database/creds/app")
+	command, fakeClient := setupTestCommand(t, vaultclient.VaultConfig{Address: fakeVault.server.URL}, map[string]string{
+		app.VaultAuthMethod:      "approle",
+		app.VaultAppRoleId:       "role-id",
+		app.VaultAppRoleSecretId: "secret-id",
+		app.VaultSourceType:      app.SourceTypeDatabase,
+		app.VaultEngine:          "database",
+		app.VaultSecretPath:      "app",
+	})
+
+	err := command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	secret := getTestSecret(t, fakeClient)
+	if secret.StringData["username"] != "user-1" {
+		t.Errorf("Expected the leased credentials, got %v", secret.StringData)
+	}
+	if secret.Annotations[kubernetes.VaultLeaseTokenAnnotation] != "accessor-login-token-1" {
+		t.Errorf("Expected the accessor of the login token, got %s", secret.Annotations[kubernetes.VaultLeaseTokenAnnotation])
+	}
+	if len(fakeVault.revokedTokenList()) != 0 {
+		t.Errorf("Expected the token owning the lease not to be revoked, got %v", fakeVault.revokedTokenList())
+	}
+	if len(fakeVault.revokedLeases()) != 0 {
+		t.Errorf("Expected no lease to be revoked, got %v", fakeVault.revokedLeases())
+	}
+}
+
+func Test_Command_GivenWatchWithNewLogin_ReplacesLeaseOfPreviousToken(t *testing.T) {
+	fakeVault := createFakeLeaseVault(t, "database/creds/app")
+	command, fakeClient := setupTestCommand(t, vaultclient.VaultConfig{Address: fakeVault.server.URL}, map[string]string{
+		app.VaultAuthMethod:      "approle",
+		app.VaultAppRoleId:       "role-id",
+		app.VaultAppRoleSecretId: "secret-id",
+		app.VaultSourceType:      app.SourceTypeDatabase,
+		app.VaultEngine:          "database",
+		app.VaultSecretPath:      "app",
+		app.WatchInterval:        "20ms",
+	})
+
+	err := command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	stop := startTestWatch(t, command)
+	waitForSecretValue(t, fakeClient, "username", "user-2")
+	waitForWatchSyncs(t, fakeClient, 2)
+	stop()
+
+	secret := getTestSecret(t, fakeClient)
+	if secret.StringData["username"] != "user-2" {
+		t.Errorf("Expected a fresh lease to be kept while the token is unchanged, got %v", secret.StringData)
+	}
+	if secret.Annotations[kubernetes.VaultLeaseTokenAnnotation] != "accessor-login-token-2" {
+		t.Errorf("Expected the accessor of the new login token, got %s", secret.Annotations[kubernetes.VaultLeaseTokenAnnotation])
+	}
+	if len(fakeVault.revokedTokenList()) != 0 {
+		t.Errorf("Expected no token owning a lease to be revoked, got %v", fakeVault.revokedTokenList())
+	}
+}
+
+func Test_Command_GivenDatabaseSourceAsConfigMap_ReturnsError(t *testing.T) {
+	parameters := getFakeKubernetesParameters(t)
+
+	commandArgs := map[string]string{
+		app.VaultAddress:      "http://localhost:8200",
+		app.VaultToken:        "token",
+		app.VaultEngine:       "database",
+		app.VaultSecretPath:   "app",
+		app.VaultSourceType:   app.SourceTypeDatabase,
+		app.ApplyAsConfigmap:  "true",
+		app.Kubeconfig:        parameters.Base64Kubeconfig,
+		app.Namespace:         parameters.Namespace,
+		app.ObjectNameToApply: "test-credentials",
+		app.VaultAuthMethod:   "token",
+	}
+
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+	if err == nil {
+		t.Error("Expected error for dynamic credentials as configmap")
+	}
+}
+
+type fakeLeaseVault struct {
+	server        *httptest.Server
+	mutex         sync.Mutex
+	leases        int
+	revoked       []string
+	logins        int
+	revokedTokens []string
+	tokenTtl      int
+}
+
+func (vault *fakeLeaseVault) setTokenTtl(seconds int) {
+	vault.mutex.Lock()
+	defer vault.mutex.Unlock()
+	vault.tokenTtl = seconds
+}
+
+func (vault *fakeLeaseVault) revokedTokenList() []string {
+	vault.mutex.Lock()
+	defer vault.mutex.Unlock()
+	return append([]string(nil), vault.revokedTokens...)
+}
+
+func (vault *fakeLeaseVault) loginCount() int {
+	vault.mutex.Lock()
+	defer vault.mutex.Unlock()
+	return vault.logins
+}
+
+func (vault *fakeLeaseVault) revokedLeases() []string {
+	vault.mutex.Lock()
+	defer vault.mutex.Unlock()
+	return append([]string(nil), vault.revoked...)
+}
+
+// createFakeLeaseVault serves new credentials with a new lease on every read of the path, a new token on every AppRole login, and records revoked leases and tokens, tokens do not expire unless a token ttl is set
+func createFakeLeaseVault(t *testing.T, path string) *fakeLeaseVault {
+	t.Helper()
+
+	vault := &fakeLeaseVault{}
+	mux := nethttp.NewServeMux()
+	mux.HandleFunc("/v1/"+path, func(writer nethttp.ResponseWriter, request *nethttp.Request) {
+		vault.mutex.Lock()
+		vault.leases++
+		lease := vault.leases
+		vault.mutex.Unlock()
+
+		writeFakeVaultResponse(writer, nethttp.StatusOK, map[string]interface{}{
+			"lease_id":       fmt.Sprintf("%s/lease-%d", path, lease),
+			"lease_duration": 3600,
+			"renewable":      true,
+			"data": map[string]interface{}{
+				"username": fmt.Sprintf("user-%d", lease),
+				"password": fmt.Sprintf("password-%d", lease),
+			},
+		})
+	})
+	mux.HandleFunc("/v1/sys/leases/revoke", func(writer nethttp.ResponseWriter, request *nethttp.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(request.Body).Decode(&body)
+
+		vault.mutex.Lock()
+		vault.revoked = append(vault.revoked, body["lease_id"])
+		vault.mutex.Unlock()
+
+		writer.WriteHeader(nethttp.StatusNoContent)
+	})
+
+	mux.HandleFunc("/v1/auth/approle/login", func(writer nethttp.ResponseWriter, request *nethttp.Request) {
+		vault.mutex.Lock()
+		vault.logins++
+		token := fmt.Sprintf("login-token-%d", vault.logins)
+		vault.mutex.Unlock()
+
+		writeFakeVaultResponse(writer, nethttp.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": token, "accessor": "accessor-" + token},
+		})
+	})
+	mux.HandleFunc("/v1/auth/token/lookup-self", func(writer nethttp.ResponseWriter, request *nethttp.Request) {
+		vault.mutex.Lock()
+		ttl := vault.tokenTtl
+		vault.mutex.Unlock()
+
+		writeFakeVaultResponse(writer, nethttp.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"accessor": "accessor-" + request.Header.Get("X-Vault-Token"), "ttl": ttl},
+		})
+	})
+	mux.HandleFunc("/v1/auth/token/revoke-self", func(writer nethttp.ResponseWriter, request *nethttp.Request) {
+		vault.mutex.Lock()
+		vault.revokedTokens = append(vault.revokedTokens, request.Header.Get("X-Vault-Token"))
+		vault.mutex.Unlock()
+
+		writer.WriteHeader(nethttp.StatusNoContent)
+	})
+
+	vault.server = httptest.NewServer(mux)
+	t.Cleanup(vault.server.Close)
+	return vault
+}
@@ -0,0 +1,236 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"k8s-from-secrets-vault/app"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestManifest(t *testing.T, content string) string {
+	t.Helper()
+	manifestPath := filepath.Join(t.TempDir(), "manifest.yaml")
+	err := os.WriteFile(manifestPath, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifestPath
+}
+
+func Test_Command_GivenManifest_AppliesEveryEntry(t *testing.T) {
+	log := setupLogger(t)
+
+	vaultClientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{
+		"DB_USER":     "admin",
+		"DB_PASSWORD": "secret",
+	})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	writeTestSecretToPath(t, vaultClientConfig, "shared", "dev/features", map[string]interface{}{"FEATURE_X": "on"})
+
+	manifestPath := writeTestManifest(t, `
+entries:
+  - path: dev/config
+    name: database
+    labels:
+      team: payments
+    keys:
+      DB_USER: username
+      DB_PASSWORD: password
+  - path: dev/features
+    engine: shared
+    kind: ConfigMap
+    name: features
+    namespace: other-namespace
+`)
+
+	parameters := getFakeKubernetesParameters(t)
+
+	commandArgs := map[string]string{
+		app.VaultAddress:    vaultClientConfig.Address,
+		app.VaultToken:      vaultClientConfig.AuthToken,
+		app.VaultEngine:     vaultClientConfig.EngineName,
+		app.SyncManifest:    manifestPath,
+		app.Kubeconfig:      parameters.Base64Kubeconfig,
+		app.Namespace:       parameters.Namespace,
+		app.VaultAuthMethod: "token",
+	}
+
+	config, err := kubernetes.CreateConfig(parameters, log)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	client, fakeClient, err := getFakeKubernetesClient(config, t)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	command, err := app.SetupCommandWithKubernetesClient(commandArgs, client)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	err = command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	secret, err := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "database", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	if secret.StringData["username"] != "admin" || secret.StringData["password"] != "secret" || len(secret.StringData) != 2 {
+		t.Errorf("Expected secret to contain only the mapped keys, got %v", secret.StringData)
+	}
+	if secret.Labels["team"] != "payments" {
+		t.Error("Expected secret to be labeled with team payments")
+	}
+
+	configMap, err := fakeClient.CoreV1().ConfigMaps("other-namespace").Get(context.TODO(), "features", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	if configMap.Data["FEATURE_X"] != "on" {
+		t.Error("Expected config map to contain FEATURE_X with value on")
+	}
+}
+
+func Test_Command_GivenManifestWithFailingEntry_AppliesRemainingEntriesAndReturnsError(t *testing.T) {
+	log := setupLogger(t)
+
+	vaultClientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"TEST_KEY": "TEST_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	manifestPath := writeTestManifest(t, `
+entries:
+  - path: dev/config
+    name: broken
+    keys:
+      MISSING_KEY: missing
+  - path: dev/config
+    name: working
+`)
+
+	parameters := getFakeKubernetesParameters(t)
+
+	commandArgs := map[string]string{
+		app.VaultAddress:    vaultClientConfig.Address,
+		app.VaultToken:      vaultClientConfig.AuthToken,
+		app.VaultEngine:     vaultClientConfig.EngineName,
+		app.SyncManifest:    manifestPath,
+		app.Kubeconfig:      parameters.Base64Kubeconfig,
+		app.Namespace:       parameters.Namespace,
+		app.VaultAuthMethod: "token",
+	}
+
+	config, err := kubernetes.CreateConfig(parameters, log)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	client, fakeClient, err := getFakeKubernetesClient(config, t)
+	if err != nil {
+		t.Error("Expected no error, got ", err)
+	}
+
+	command, err := app.SetupCommandWithKubernetesClient(commandArgs, client)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	err = command.Execute()
+	if err == nil || err.Error() != "1 of 2 manifest entries failed" {
+		t.Errorf("Expected error to be '1 of 2 manifest entries failed', got '%v'", err)
+	}
+
+	_, err = fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "working", metav1.GetOptions{})
+	if err != nil {
+		t.Error("Expected the valid entry to be applied, got ", err)
+	}
+}
+
+func Test_Command_GivenManifestKeyMappingOfBase64Keys_DecodesRenamedKeys(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(testKeystore)
+	manifestPath := writeTestManifest(t, `
+entries:
+  - path: dev/config
+    name: test-secret
+    keys:
+      keystore_b64: keystore.p12
+      truststore: truststore.jks
+      password: password
+`)
+	command, fakeClient := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"keystore_b64": encoded,
+		"truststore":   encoded,
+		"password":     "changeit",
+	}, map[string]string{
+		app.SyncManifest:    manifestPath,
+		app.Base64KeySuffix: "_b64",
+		app.Base64Keys:      "truststore",
+	})
+
+	err := command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	secret := getTestSecret(t, fakeClient)
+	for _, key := range []string{"keystore.p12", "truststore.jks"} {
+		if !bytes.Equal(secret.Data[key], testKeystore) {
+			t.Errorf("Expected the decoded value under %s, got %v", key, secret.Data[key])
+		}
+	}
+	if secret.StringData["password"] != "changeit" || len(secret.StringData) != 1 {
+		t.Errorf("Expected only the text value in string data, got %v", secret.StringData)
+	}
+}
+
+func Test_GivenInvalidManifest_ReturnsErrorForEveryInvalidEntry(t *testing.T) {
+	//Arrange
+	manifestPath := writeTestManifest(t, `
+entries:
+  - path: dev/config
+    name: Invalid_Name
+  - path: dev/config
+    name: valid-name
+    kind: Deployment
+  - path: dev/config
+    name: duplicated-keys
+    keys:
+      DB_USER: username
+      USER: username
+`)
+
+	commandArgs := map[string]string{
+		app.VaultAddress:    "http://",
+		app.VaultToken:      "test-token",
+		app.VaultEngine:     "test-engine",
+		app.SyncManifest:    manifestPath,
+		app.Namespace:       "test-namespace",
+		app.Kubeconfig:      "test-kubeconfig",
+		app.VaultAuthMethod: "token",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if !strings.Contains(err.Error(), "entry 0: name \"Invalid_Name\" is invalid") {
+		t.Errorf("Expected error to report the invalid name of entry 0, got '%v'", err)
+	}
+	if !strings.Contains(err.Error(), "entry 1: kind must be Secret or ConfigMap") {
+		t.Errorf("Expected error to report the invalid kind of entry 1, got '%v'", err)
+	}
+	if !strings.Contains(err.Error(), "entry 2: keys DB_USER and USER both map to username") {
+		t.Errorf("Expected error to report the duplicated key mapping of entry 2, got '%v'", err)
+	}
+}
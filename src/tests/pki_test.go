@@ -0,0 +1,182 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"k8s-from-secrets-vault/app"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	vaultclient "k8s-from-secrets-vault/vault"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Command_GivenPkiSource_IssuesCertificateIntoTlsSecret(t *testing.T) {
+	command, fakeClient := setupTestCommand(t, createTestPkiVault(t), pkiTestArgs)
+
+	var out bytes.Buffer
+	err := command.Diff(&out)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	if out.String() != "+ Secret test-namespace/test-secret\n    + ca.crt\n    + tls.crt\n    + tls.key\n" {
+		t.Errorf("Unexpected plan\n%s", out.String())
+	}
+
+	err = command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	secret := getTestSecret(t, fakeClient)
+	if secret.Type != corev1.SecretTypeTLS {
+		t.Errorf("Expected secret type %s, got %s", corev1.SecretTypeTLS, secret.Type)
+	}
+	if !strings.Contains(secret.StringData["tls.key"], "PRIVATE KEY") || !strings.Contains(secret.StringData["ca.crt"], "CERTIFICATE") {
+		t.Error("Expected private key and CA certificate to be applied")
+	}
+
+	certificate := parseTestCertificate(t, secret.StringData["tls.crt"])
+	if certificate.Subject.CommonName != "web.example.com" {
+		t.Errorf("Expected common name web.example.com, got %s", certificate.Subject.CommonName)
+	}
+	if len(certificate.DNSNames) != 3 {
+		t.Errorf("Expected the common name and both alt names as SANs, got %v", certificate.DNSNames)
+	}
+}
+
+func Test_Command_GivenPkiSourceWithOpaqueType_ReturnsError(t *testing.T) {
+	commandArgs := map[string]string{
+		app.VaultAddress:      "http://",
+		app.VaultToken:        "test-token",
+		app.VaultEngine:       "pki",
+		app.VaultSecretPath:   "web",
+		app.Namespace:         "test-namespace",
+		app.Kubeconfig:        "test-kubeconfig",
+		app.ObjectNameToApply: "test-secret",
+		app.VaultAuthMethod:   "token",
+		app.SecretType:        kubernetes.SecretTypeOpaque,
+	}
+	for key, value := range pkiTestArgs {
+		commandArgs[key] = value
+	}
+
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+	if err == nil || err.Error() != "Vault PKI certificates are always applied as kubernetes.io/tls secrets" {
+		t.Error("Expected an explicit Opaque type to be rejected, got ", err)
+	}
+}
+
+func Test_Command_GivenPkiSourceWithValidCertificate_SkipsIssue(t *testing.T) {
+	command, fakeClient := setupTestCommand(t, createTestPkiVault(t), pkiTestArgs, map[string]string{app.VaultPkiRenewBefore: "1h"})
+
+	err := command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	issued := getTestSecret(t, fakeClient).StringData["tls.crt"]
+
+	err = command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	if getTestSecret(t, fakeClient).StringData["tls.crt"] != issued {
+		t.Error("Expected the valid certificate not to be re-issued")
+	}
+
+	command.PkiRenewBefore = 48 * time.Hour
+	err = command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	if getTestSecret(t, fakeClient).StringData["tls.crt"] == issued {
+		t.Error("Expected a certificate inside the renewal window to be re-issued")
+	}
+}
+
+func Test_Command_GivenPkiSourceWithChangedNames_ReissuesValidCertificate(t *testing.T) {
+	command, fakeClient := setupTestCommand(t, createTestPkiVault(t), pkiTestArgs, map[string]string{app.VaultPkiRenewBefore: "1h"})
+
+	err := command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	command.PkiAltNames = "www.example.com"
+	err = command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	certificate := parseTestCertificate(t, getTestSecret(t, fakeClient).StringData["tls.crt"])
+	if len(certificate.DNSNames) != 2 {
+		t.Errorf("Expected a certificate re-issued for the changed alt names, got %v", certificate.DNSNames)
+	}
+
+	command.PkiCommonName = "api.example.com"
+	err = command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	certificate = parseTestCertificate(t, getTestSecret(t, fakeClient).StringData["tls.crt"])
+	if certificate.Subject.CommonName != "api.example.com" {
+		t.Errorf("Expected a certificate re-issued for the changed common name, got %s", certificate.Subject.CommonName)
+	}
+}
+
+func Test_Command_GivenPkiSourceWithoutCommonName_ReturnsError(t *testing.T) {
+	parameters := getFakeKubernetesParameters(t)
+
+	commandArgs := map[string]string{
+		app.VaultAddress:      "http://localhost:8200",
+		app.VaultToken:        "token",
+		app.VaultEngine:       "pki",
+		app.VaultSecretPath:   "web",
+		app.VaultSourceType:   app.SourceTypePki,
+		app.Kubeconfig:        parameters.Base64Kubeconfig,
+		app.Namespace:         parameters.Namespace,
+		app.ObjectNameToApply: "test-certificate",
+		app.VaultAuthMethod:   "token",
+	}
+
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+	if err == nil {
+		t.Error("Expected error for missing common name")
+	}
+}
+
+var pkiTestArgs = map[string]string{
+	app.VaultSourceType:    app.SourceTypePki,
+	app.VaultPkiCommonName: "web.example.com",
+	app.VaultPkiAltNames:   "www.example.com, api.example.com",
+	app.VaultPkiTtl:        "24h",
+}
+
+// createTestPkiVault starts a test Vault with a PKI engine and its web role, the returned config issues from that role
+func createTestPkiVault(t *testing.T) vaultclient.VaultConfig {
+	t.Helper()
+
+	vaultClientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, nil)
+	t.Cleanup(func() { destroyVaultHttpListener(t, vaultHttpListener) })
+
+	setupTestPki(t, vaultClientConfig, "pki", "web")
+
+	vaultClientConfig.EngineName = "pki"
+	vaultClientConfig.SecretPath = "web"
+	return vaultClientConfig
+}
+
+func parseTestCertificate(t *testing.T, certificatePem string) *x509.Certificate {
+	t.Helper()
+
+	block, _ := pem.Decode([]byte(certificatePem))
+	if block == nil {
+		t.Fatal("Expected a PEM certificate")
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"k8s-from-secrets-vault/app"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"strings"
+	"testing"
+)
+
+var pruneTestVaultSecret = map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"}
+
+func Test_Command_GivenPrune_RemovesKeysMissingFromVault(t *testing.T) {
+	command, fakeClient := setupTestCommandWithSecrets(t, pruneTestVaultSecret, map[string]string{app.Prune: "true", app.PruneForce: "true"})
+	createPruneTestSecret(t, fakeClient)
+
+	var out bytes.Buffer
+	err := command.Diff(&out)
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	for _, expected := range []string{"- STALE_KEY", "- FOREIGN_KEY"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected plan to contain %s, got\n%s", expected, out.String())
+		}
+	}
+
+	err = command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	data := getTestSecretData(t, fakeClient)
+	if data["CONFIG_KEY"] != "CONFIG_VALUE" || len(data) != 1 {
+		t.Errorf("Expected only the Vault keys, got %v", data)
+	}
+	if _, exists := data["STALE_KEY"]; exists {
+		t.Error("Expected STALE_KEY to be pruned")
+	}
+	if _, exists := data["FOREIGN_KEY"]; exists {
+		t.Error("Expected FOREIGN_KEY to be pruned when forced")
+	}
+}
+
+func Test_Command_GivenPruneWithoutForce_RefusesKeysOwnedByOtherManagers(t *testing.T) {
+	command, fakeClient := setupTestCommandWithSecrets(t, pruneTestVaultSecret, map[string]string{app.Prune: "true"})
+	createPruneTestSecret(t, fakeClient)
+
+	err := command.Execute()
+	if err == nil || !strings.Contains(err.Error(), "FOREIGN_KEY (kubectl-edit)") {
+		t.Fatal("Expected error about the foreign key, got ", err)
+	}
+
+	data := getTestSecretData(t, fakeClient)
+	if _, exists := data["STALE_KEY"]; !exists {
+		t.Error("Expected no key to be pruned when pruning is refused")
+	}
+	if _, exists := data["CONFIG_KEY"]; exists {
+		t.Error("Expected the Vault keys not to be applied when pruning is refused")
+	}
+}
+
+func Test_Command_GivenPruneWithUnreadableManagedFields_RefusesToPrune(t *testing.T) {
+	command, fakeClient := setupTestCommandWithSecrets(t, pruneTestVaultSecret, map[string]string{app.Prune: "true"})
+	_, err := fakeClient.CoreV1().Secrets("test-namespace").Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "test-namespace",
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kubectl-edit", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:data":["f:STALE_KEY"]}`)}},
+			},
+		},
+		Data: map[string][]byte{"STALE_KEY": []byte("STALE_VALUE")},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = command.Execute()
+	if err == nil || !strings.Contains(err.Error(), "kubectl-edit") {
+		t.Fatal("Expected error about the unreadable managed fields, got ", err)
+	}
+
+	data := getTestSecretData(t, fakeClient)
+	if _, exists := data["STALE_KEY"]; !exists {
+		t.Error("Expected no key to be pruned when the managed fields cannot be read")
+	}
+}
+
+func Test_Command_GivenPruneWithoutForce_PlanRefusesKeysOwnedByOtherManagers(t *testing.T) {
+	command, fakeClient := setupTestCommandWithSecrets(t, pruneTestVaultSecret, map[string]string{app.Prune: "true"})
+	createPruneTestSecret(t, fakeClient)
+
+	var out bytes.Buffer
+	err := command.Diff(&out)
+	if err == nil || !strings.Contains(err.Error(), "FOREIGN_KEY (kubectl-edit)") {
+		t.Fatal("Expected the plan to refuse the foreign key, got ", err)
+	}
+	if strings.Contains(out.String(), "- FOREIGN_KEY") {
+		t.Errorf("Expected the plan not to report the foreign key removal, got\n%s", out.String())
+	}
+}
+
+func Test_Command_GivenNoPrune_KeepsKeysMissingFromVault(t *testing.T) {
+	command, fakeClient := setupTestCommandWithSecrets(t, pruneTestVaultSecret, map[string]string{})
+	createPruneTestSecret(t, fakeClient)
+
+	err := command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	data := getTestSecretData(t, fakeClient)
+	if data["CONFIG_KEY"] != "CONFIG_VALUE" || data["STALE_KEY"] == "" || data["FOREIGN_KEY"] == "" {
+		t.Errorf("Expected Vault keys merged into the existing keys, got %v", data)
+	}
+}
+
+func Test_Command_GivenPruneForceWithoutPrune_ReturnsError(t *testing.T) {
+	parameters := getFakeKubernetesParameters(t)
+
+	commandArgs := map[string]string{
+		app.VaultAddress:      "http://localhost:8200",
+		app.VaultToken:        "token",
+		app.VaultEngine:       "secret",
+		app.VaultSecretPath:   "config",
+		app.Kubeconfig:        parameters.Base64Kubeconfig,
+		app.Namespace:         parameters.Namespace,
+		app.ObjectNameToApply: "test-secret",
+		app.VaultAuthMethod:   "token",
+		app.PruneForce:        "true",
+	}
+
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+	if err == nil {
+		t.Error("Expected error for prune force without prune")
+	}
+}
+
+// createPruneTestSecret seeds test-secret with a key written by this tool and one owned by another field manager
+func createPruneTestSecret(t *testing.T, fakeClient *fake.Clientset) {
+	t.Helper()
+
+	_, err := fakeClient.CoreV1().Secrets("test-namespace").Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "test-namespace",
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "k8s-from-secrets-vault", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:data":{"f:STALE_KEY":{}}}`)}},
+				{Manager: "kubectl-edit", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:data":{"f:FOREIGN_KEY":{}}}`)}},
+			},
+		},
+		Data: map[string][]byte{
+			"STALE_KEY":   []byte("STALE_VALUE"),
+			"FOREIGN_KEY": []byte("FOREIGN_VALUE"),
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
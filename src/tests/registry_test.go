@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"k8s-from-secrets-vault/app"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	vaultclient "k8s-from-secrets-vault/vault"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+)
+
+func Test_Command_GivenImagePullSecret_AppliesDockerConfigJsonWithEveryRegistry(t *testing.T) {
+	command, fakeClient := setupTestCommand(t, createTestRegistryVault(t, map[string]interface{}{"host": "ghcr.io", "user": "ghcr-user", "token": "ghcr-token"}), imagePullSecretTestArgs)
+
+	err := command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	secret := getTestSecret(t, fakeClient)
+	if secret.Type != corev1.SecretTypeDockerConfigJson {
+		t.Errorf("Expected secret type %s, got %s", corev1.SecretTypeDockerConfigJson, secret.Type)
+	}
+
+	var config struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Email    string `json:"email"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}
+	err = json.Unmarshal([]byte(secret.StringData[corev1.DockerConfigJsonKey]), &config)
+	if err != nil {
+		t.Fatal("Expected valid dockerconfigjson, got ", err)
+	}
+
+	if len(config.Auths) != 2 {
+		t.Fatalf("Expected 2 registries, got %d", len(config.Auths))
+	}
+	ghcr := config.Auths["ghcr.io"]
+	if ghcr.Username != "ghcr-user" || ghcr.Auth != base64.StdEncoding.EncodeToString([]byte("ghcr-user:ghcr-token")) {
+		t.Errorf("Unexpected ghcr.io entry %+v", ghcr)
+	}
+	private := config.Auths["registry.example.com"]
+	if private.Username != "robot" || private.Email != "robot@example.com" || private.Auth != base64.StdEncoding.EncodeToString([]byte("robot:robot-token")) {
+		t.Errorf("Unexpected registry.example.com entry %+v", private)
+	}
+}
+
+func Test_Command_GivenImagePullSecretWithDuplicateRegistry_ReturnsError(t *testing.T) {
+	command, fakeClient := setupTestCommand(t, createTestRegistryVault(t, map[string]interface{}{"host": "registry.example.com", "user": "user", "token": "token"}), imagePullSecretTestArgs)
+
+	err := command.Execute()
+	if err == nil || !strings.Contains(err.Error(), "defined more than once") {
+		t.Fatal("Expected duplicate registry error, got ", err)
+	}
+
+	_, err = fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err == nil {
+		t.Error("Expected no secret to be applied")
+	}
+}
+
+func Test_Command_GivenImagePullSecretWithMissingKey_ReturnsError(t *testing.T) {
+	command, _ := setupTestCommand(t, createTestRegistryVault(t, map[string]interface{}{"host": "ghcr.io", "user": "ghcr-user"}), imagePullSecretTestArgs)
+
+	err := command.Execute()
+	if err == nil || !strings.Contains(err.Error(), "registry key token not found") {
+		t.Fatal("Expected missing password error, got ", err)
+	}
+}
+
+func Test_Command_GivenImagePullSecretWithOpaqueType_ReturnsError(t *testing.T) {
+	commandArgs := map[string]string{
+		app.VaultAddress:      "http://",
+		app.VaultToken:        "test-token",
+		app.VaultEngine:       "test-engine",
+		app.VaultSecretPath:   "test-path",
+		app.Namespace:         "test-namespace",
+		app.Kubeconfig:        "test-kubeconfig",
+		app.ObjectNameToApply: "test-secret",
+		app.VaultAuthMethod:   "token",
+		app.SecretType:        kubernetes.SecretTypeOpaque,
+	}
+	for key, value := range imagePullSecretTestArgs {
+		commandArgs[key] = value
+	}
+
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+	if err == nil || err.Error() != "Image pull secret type is always kubernetes.io/dockerconfigjson" {
+		t.Error("Expected an explicit Opaque type to be rejected, got ", err)
+	}
+}
+
+var imagePullSecretTestArgs = map[string]string{
+	app.ImagePullSecret:    "true",
+	app.RegistryKeyMapping: "server=host,username=user,password=token",
+}
+
+// createTestRegistryVault starts a test Vault holding the given registry and a private one, the returned config reads both
+func createTestRegistryVault(t *testing.T, registry map[string]interface{}) vaultclient.VaultConfig {
+	t.Helper()
+
+	vaultClientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, registry)
+	t.Cleanup(func() { destroyVaultHttpListener(t, vaultHttpListener) })
+
+	writeTestSecretToPath(t, vaultClientConfig, vaultClientConfig.EngineName, "registries/private", map[string]interface{}{
+		"host":  "registry.example.com",
+		"user":  "robot",
+		"token": "robot-token",
+		"email": "robot@example.com",
+	})
+
+	vaultClientConfig.SecretPath += ",registries/private"
+	return vaultClientConfig
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"context"
+	"k8s-from-secrets-vault/app"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+)
+
+func Test_Command_GivenTlsSecretTypeAndKeyMapping_AppliesTlsSecret(t *testing.T) {
+	certificate, privateKey := generateTestCertificate(t, "test.example.com")
+
+	command, fakeClient := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"certificate": certificate,
+		"private_key": privateKey,
+	}, map[string]string{
+		app.SecretType:       kubernetes.SecretTypeTls,
+		app.SecretKeyMapping: "tls.crt=certificate, tls.key=private_key",
+	})
+
+	err := command.Execute()
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+
+	secret, err := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Type != corev1.SecretTypeTLS {
+		t.Errorf("Expected secret type %s, got %s", corev1.SecretTypeTLS, secret.Type)
+	}
+	if secret.StringData["tls.crt"] != certificate || secret.StringData["tls.key"] != privateKey {
+		t.Error("Expected the mapped certificate and key")
+	}
+	if _, exists := secret.StringData["certificate"]; exists {
+		t.Error("Expected the mapped Vault key to be renamed")
+	}
+}
+
+func Test_Command_GivenInvalidTypedSecretData_DoesNotApply(t *testing.T) {
+	testCases := map[string]struct {
+		secretType string
+		data       map[string]interface{}
+		expected   string
+	}{
+		"invalid tls pem": {
+			secretType: kubernetes.SecretTypeTls,
+			data:       map[string]interface{}{"tls.crt": "not a certificate", "tls.key": "not a key"},
+			expected:   "not a valid PEM certificate",
+		},
+		"missing tls key": {
+			secretType: kubernetes.SecretTypeTls,
+			data:       map[string]interface{}{"tls.crt": "certificate"},
+			expected:   "tls.key is required",
+		},
+		"invalid dockerconfigjson": {
+			secretType: kubernetes.SecretTypeDockerConfigJson,
+			data:       map[string]interface{}{".dockerconfigjson": "{\"auths\":"},
+			expected:   "not valid JSON",
+		},
+		"dockerconfigjson without auths": {
+			secretType: kubernetes.SecretTypeDockerConfigJson,
+			data:       map[string]interface{}{".dockerconfigjson": "{}"},
+			expected:   "no auths entries",
+		},
+		"ssh-auth without pem": {
+			secretType: kubernetes.SecretTypeSshAuth,
+			data:       map[string]interface{}{"ssh-privatekey": "key"},
+			expected:   "not a PEM encoded private key",
+		},
+		"basic-auth without credentials": {
+			secretType: kubernetes.SecretTypeBasicAuth,
+			data:       map[string]interface{}{"token": "value"},
+			expected:   "username or password is required",
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			command, fakeClient := setupTestCommandWithSecrets(t, testCase.data, map[string]string{app.SecretType: testCase.secretType})
+
+			err := command.Execute()
+			if err == nil || !strings.Contains(err.Error(), testCase.expected) {
+				t.Fatalf("Expected error containing %q, got %v", testCase.expected, err)
+			}
+
+			_, err = fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+			if err == nil {
+				t.Error("Expected the invalid secret not to be applied")
+			}
+		})
+	}
+}
+
+func Test_Command_GivenInvalidSecretTypeSettings_ReturnsError(t *testing.T) {
+	parameters := getFakeKubernetesParameters(t)
+
+	testCases := map[string]map[string]string{
+		"unknown type":         {app.SecretType: "kubernetes.io/unknown"},
+		"configmap with type":  {app.SecretType: kubernetes.SecretTypeTls, app.ApplyAsConfigmap: "true"},
+		"malformed mapping":    {app.SecretType: kubernetes.SecretTypeTls, app.SecretKeyMapping: "tls.crt"},
+		"key not of that type": {app.SecretType: kubernetes.SecretTypeTls, app.SecretKeyMapping: "password=secret"},
+	}
+
+	for name, extraArgs := range testCases {
+		t.Run(name, func(t *testing.T) {
+			commandArgs := map[string]string{
+				app.VaultAddress:      "http://localhost:8200",
+				app.VaultToken:        "token",
+				app.VaultEngine:       "secret",
+				app.VaultSecretPath:   "config",
+				app.Kubeconfig:        parameters.Base64Kubeconfig,
+				app.Namespace:         parameters.Namespace,
+				app.ObjectNameToApply: "test-secret",
+				app.VaultAuthMethod:   "token",
+			}
+			for key, value := range extraArgs {
+				commandArgs[key] = value
+			}
+
+			_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+			if err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
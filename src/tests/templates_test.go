@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"context"
+	"k8s-from-secrets-vault/app"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Command_GivenInlineTemplate_RendersVaultDataIntoKey(t *testing.T) {
+	//Arrange
+	command, fakeClient := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"db_user":     "admin",
+		"db_password": "s3cret",
+	}, map[string]string{
+		app.TemplateKey: "application.properties",
+		app.Template: "db.user={{ .db_user }}\n" +
+			"db.password={{ .db_password | b64enc }}\n" +
+			"log.level={{ .log_level | default \"info\" }}\n" +
+			"log.format={{ .log_format }}\n",
+	})
+
+	//Act
+	err := command.Execute()
+
+	//Assert
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	secret, err := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "db.user=admin\ndb.password=czNjcmV0\nlog.level=info\nlog.format=\n"
+	if secret.StringData["application.properties"] != expected {
+		t.Errorf("Expected %q, got %q", expected, secret.StringData["application.properties"])
+	}
+	if secret.StringData["db_user"] != "admin" {
+		t.Error("Expected the Vault keys to be applied next to the rendered key")
+	}
+}
+
+func Test_Command_GivenTemplateFiles_RendersEveryFileIntoItsKey(t *testing.T) {
+	//Arrange
+	directory := t.TempDir()
+	yamlTemplate := filepath.Join(directory, "config.yaml.tmpl")
+	jsonTemplate := filepath.Join(directory, "settings.tpl")
+	if err := os.WriteFile(yamlTemplate, []byte("database:\n{{ toYaml . | indent 2 }}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(jsonTemplate, []byte("{{ toJson . }}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	command, fakeClient := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"host": "db.local",
+		"user": "admin",
+	}, map[string]string{
+		app.ApplyAsConfigmap: "true",
+		app.KeyExclude:       "*",
+		app.TemplateFiles:    yamlTemplate + ",settings.json=" + jsonTemplate,
+	})
+
+	//Act
+	err := command.Execute()
+
+	//Assert
+	if err != nil {
+		t.Fatal("Expected no error, got ", err)
+	}
+	configMap, err := fakeClient.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configMap.Data) != 2 {
+		t.Errorf("Expected only the rendered keys, got %v", configMap.Data)
+	}
+	if configMap.Data["config.yaml"] != "database:\n  host: db.local\n  user: admin\n" {
+		t.Errorf("Unexpected config.yaml %q", configMap.Data["config.yaml"])
+	}
+	if configMap.Data["settings.json"] != `{"host":"db.local","user":"admin"}` {
+		t.Errorf("Unexpected settings.json %q", configMap.Data["settings.json"])
+	}
+}
+
+func Test_Command_GivenTemplateKeyCollidingWithVaultKey_ReturnsError(t *testing.T) {
+	//Arrange
+	command, _ := setupTestCommandWithSecrets(t, map[string]interface{}{
+		"config": "value",
+	}, map[string]string{
+		app.TemplateKey: "config",
+		app.Template:    "{{ .config }}",
+	})
+
+	//Act
+	err := command.Execute()
+
+	//Assert
+	if err == nil || !strings.Contains(err.Error(), "Template key config collides with a Vault key") {
+		t.Fatal("Expected a collision error, got ", err)
+	}
+}
+
+func Test_GivenInvalidTemplate_ReturnsError(t *testing.T) {
+	cases := map[string]map[string]string{
+		"Template key is required for an inline template": {app.Template: "{{ .user }}"},
+		"Template for key app.conf is invalid":            {app.Template: "{{ .user ", app.TemplateKey: "app.conf"},
+		"Template key \"app conf\" is not a valid":        {app.Template: "{{ .user }}", app.TemplateKey: "app conf"},
+		"Failed to read template file missing.tmpl":       {app.TemplateFiles: "missing.tmpl"},
+	}
+	for expected, args := range cases {
+		//Arrange
+		commandArgs := map[string]string{
+			app.VaultAddress:      "http://",
+			app.VaultToken:        "test-token",
+			app.VaultEngine:       "test-engine",
+			app.VaultSecretPath:   "test-path",
+			app.Namespace:         "test-namespace",
+			app.Kubeconfig:        "test-kubeconfig",
+			app.ObjectNameToApply: "test-secret",
+			app.VaultAuthMethod:   "token",
+		}
+		for key, value := range args {
+			commandArgs[key] = value
+		}
+
+		//Act
+		_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+		//Assert
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,563 @@
+package tests
+
+import (
+	"github.com/hashicorp/vault/api"
+	"k8s-from-secrets-vault/app"
+	vaultclient "k8s-from-secrets-vault/vault"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_WhenAuthMethodIsJwt_GivenMissingRequiredRole_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:      "http://",
+		app.VaultAuthMethod:   "jwt",
+		app.OidcRequestUrl:    "http://oidc",
+		app.OidcRequestToken:  "request-token",
+		app.VaultEngine:       "test-engine",
+		app.VaultSecretPath:   "test-path",
+		app.Namespace:         "test-namespace",
+		app.ApplyAsConfigmap:  "false",
+		app.Kubeconfig:        "test-kubeconfig",
+		app.ObjectNameToApply: "test-secret",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if err.Error() != "Vault auth role is required" {
+		t.Error("Expected error to be 'Vault auth role is required'")
+	}
+}
+
+func Test_WhenAuthMethodIsJwt_GivenMissingOidcRequestVariables_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:      "http://",
+		app.VaultAuthMethod:   "jwt",
+		app.VaultAuthRole:     "deployer",
+		app.VaultEngine:       "test-engine",
+		app.VaultSecretPath:   "test-path",
+		app.Namespace:         "test-namespace",
+		app.ApplyAsConfigmap:  "false",
+		app.Kubeconfig:        "test-kubeconfig",
+		app.ObjectNameToApply: "test-secret",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if !strings.Contains(err.Error(), "id-token: write") {
+		t.Errorf("Expected error to mention id-token permission, got '%v'", err)
+	}
+}
+
+func Test_VaultClient_GivenJwtAuth_LogsInWithGithubOidcToken(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	oidcProvider := createFakeGithubOidcProvider(t, "request-token", "https://vault.example.com", "signed-jwt")
+	fakeVault := createFakeVaultWithLogin(t, "auth/github-actions/login", map[string]string{
+		"role": "deployer",
+		"jwt":  "signed-jwt",
+	}, "application/data/dev/config", map[string]interface{}{"TEST_KEY": "TEST_VALUE"})
+
+	clientConfig := getTestVaultConfigWithAuthMethod("jwt")
+	clientConfig.Address = fakeVault.URL
+	clientConfig.AuthMount = "github-actions"
+	clientConfig.AuthRole = "deployer"
+	clientConfig.JwtAudience = "https://vault.example.com"
+	clientConfig.OidcRequestUrl = oidcProvider.URL + "/token?api-version=2.0"
+	clientConfig.OidcRequestToken = "request-token"
+
+	//Act
+	secretData, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secretData["TEST_KEY"] != "TEST_VALUE" {
+		t.Error("Expected secret data to contain TEST_KEY with value TEST_VALUE")
+	}
+}
+
+func Test_VaultClient_GivenJwtAuthWithRejectedRole_ReturnsError(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	oidcProvider := createFakeGithubOidcProvider(t, "request-token", "", "signed-jwt")
+	fakeVault := createFakeVaultWithLogin(t, "auth/jwt/login", map[string]string{
+		"role": "deployer",
+	}, "application/data/dev/config", map[string]interface{}{})
+
+	clientConfig := getTestVaultConfigWithAuthMethod("jwt")
+	clientConfig.Address = fakeVault.URL
+	clientConfig.AuthRole = "other-role"
+	clientConfig.OidcRequestUrl = oidcProvider.URL + "/token"
+	clientConfig.OidcRequestToken = "request-token"
+
+	//Act
+	_, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if !strings.Contains(err.Error(), "invalid role") {
+		t.Errorf("Expected error to be 'invalid role', got '%v'", err)
+	}
+}
+
+func Test_WhenAuthMethodIsKubernetes_GivenMissingServiceAccountToken_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:       "http://",
+		app.VaultAuthMethod:    "kubernetes",
+		app.VaultAuthRole:      "deployer",
+		app.VaultKubeTokenPath: filepath.Join(t.TempDir(), "token"),
+		app.VaultEngine:        "test-engine",
+		app.VaultSecretPath:    "test-path",
+		app.Namespace:          "test-namespace",
+		app.ApplyAsConfigmap:   "false",
+		app.Kubeconfig:         "test-kubeconfig",
+		app.ObjectNameToApply:  "test-secret",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if !strings.HasPrefix(err.Error(), "Kubernetes service account token not found") {
+		t.Errorf("Expected error to be 'Kubernetes service account token not found', got '%v'", err)
+	}
+}
+
+func Test_WhenAuthMethodIsKubernetes_GivenMissingRole_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:      "http://",
+		app.VaultAuthMethod:   "kubernetes",
+		app.VaultEngine:       "test-engine",
+		app.VaultSecretPath:   "test-path",
+		app.Namespace:         "test-namespace",
+		app.ApplyAsConfigmap:  "false",
+		app.Kubeconfig:        "test-kubeconfig",
+		app.ObjectNameToApply: "test-secret",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if err.Error() != "Vault auth role is required" {
+		t.Error("Expected error to be 'Vault auth role is required'")
+	}
+}
+
+func Test_VaultClient_GivenKubernetesAuth_LogsInWithServiceAccountToken(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(tokenPath, []byte("service-account-jwt\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fakeVault := createFakeVaultWithLogin(t, "auth/cluster-a/login", map[string]string{
+		"role": "runner",
+		"jwt":  "service-account-jwt",
+	}, "application/data/dev/config", map[string]interface{}{"TEST_KEY": "TEST_VALUE"})
+
+	clientConfig := getTestVaultConfigWithAuthMethod("kubernetes")
+	clientConfig.Address = fakeVault.URL
+	clientConfig.AuthMount = "cluster-a"
+	clientConfig.AuthRole = "runner"
+	clientConfig.KubernetesTokenPath = tokenPath
+
+	//Act
+	secretData, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secretData["TEST_KEY"] != "TEST_VALUE" {
+		t.Error("Expected secret data to contain TEST_KEY with value TEST_VALUE")
+	}
+}
+
+func Test_VaultClient_GivenUserpassAuth_LogsInWithUsernameAndPassword(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"TEST_KEY": "TEST_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+	setupTestUserpass(t, clientConfig, "operators", "jdoe", "s3cret")
+
+	clientConfig.AuthMethod = "userpass"
+	clientConfig.AuthMount = "operators"
+	clientConfig.AuthToken = ""
+	clientConfig.Username = "jdoe"
+	clientConfig.Password = "s3cret"
+	clientConfig.KvVersion = vaultclient.KvVersion2
+
+	//Act
+	secretData, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secretData["TEST_KEY"] != "TEST_VALUE" {
+		t.Error("Expected secret data to contain TEST_KEY with value TEST_VALUE")
+	}
+}
+
+func Test_VaultClient_GivenUserpassAuthWithWrongPassword_ReturnsError(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"TEST_KEY": "TEST_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+	setupTestUserpass(t, clientConfig, "userpass", "jdoe", "s3cret")
+
+	clientConfig.AuthMethod = "userpass"
+	clientConfig.AuthToken = ""
+	clientConfig.Username = "jdoe"
+	clientConfig.Password = "wrong"
+
+	//Act
+	_, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err == nil {
+		t.Error("Expected the wrong password to be rejected")
+	}
+}
+
+func Test_VaultClient_GivenLdapAuth_LogsInAtUsernamePath(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	fakeVault := createFakeVaultWithLogin(t, "auth/corp-ldap/login/jdoe", map[string]string{
+		"password": "s3cret",
+	}, "application/data/dev/config", map[string]interface{}{"TEST_KEY": "TEST_VALUE"})
+
+	clientConfig := getTestVaultConfigWithAuthMethod("ldap")
+	clientConfig.Address = fakeVault.URL
+	clientConfig.AuthMount = "corp-ldap"
+	clientConfig.Username = "jdoe"
+	clientConfig.Password = "s3cret"
+
+	//Act
+	secretData, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secretData["TEST_KEY"] != "TEST_VALUE" {
+		t.Error("Expected secret data to contain TEST_KEY with value TEST_VALUE")
+	}
+}
+
+func Test_WhenAuthMethodIsLdap_GivenMissingPasswordWithoutTerminal_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:      "http://",
+		app.VaultEngine:       "test-engine",
+		app.VaultSecretPath:   "test-path",
+		app.Namespace:         "test-namespace",
+		app.Kubeconfig:        "test-kubeconfig",
+		app.ObjectNameToApply: "test-secret",
+		app.VaultAuthMethod:   "ldap",
+		app.VaultUsername:     "jdoe",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil || err.Error() != "Vault password is required, set it in the environment or run from a terminal to be prompted" {
+		t.Error("Expected missing password error, got ", err)
+	}
+}
+
+func Test_WhenAuthMethodIsUserpass_GivenMissingUsername_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:      "http://",
+		app.VaultEngine:       "test-engine",
+		app.VaultSecretPath:   "test-path",
+		app.Namespace:         "test-namespace",
+		app.Kubeconfig:        "test-kubeconfig",
+		app.ObjectNameToApply: "test-secret",
+		app.VaultAuthMethod:   "userpass",
+		app.VaultPassword:     "s3cret",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil || err.Error() != "Vault username is required" {
+		t.Error("Expected missing username error, got ", err)
+	}
+}
+
+func Test_VaultClient_GivenWrappedAppRoleSecretId_UnwrapsAndLogsIn(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"TEST_KEY": "TEST_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+	roleId, _ := setupTestAppRole(t, clientConfig, "1h", "2h")
+
+	appRoleConfig := clientConfig
+	appRoleConfig.AuthMethod = "approle"
+	appRoleConfig.AppRoleId = roleId
+	appRoleConfig.WrappedSecretId = wrapTestAppRoleSecretId(t, clientConfig)
+	appRoleConfig.KvVersion = vaultclient.KvVersion2
+
+	//Act
+	secretData, err := vaultclient.LoadSecretData(appRoleConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secretData["TEST_KEY"] != "TEST_VALUE" {
+		t.Error("Expected secret data to contain TEST_KEY with value TEST_VALUE")
+	}
+}
+
+func Test_VaultClient_GivenAlreadyUnwrappedSecretId_ReturnsError(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"TEST_KEY": "TEST_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+	roleId, _ := setupTestAppRole(t, clientConfig, "1h", "2h")
+
+	appRoleConfig := clientConfig
+	appRoleConfig.AuthMethod = "approle"
+	appRoleConfig.AppRoleId = roleId
+	appRoleConfig.WrappedSecretId = wrapTestAppRoleSecretId(t, clientConfig)
+	appRoleConfig.KvVersion = vaultclient.KvVersion2
+
+	_, err := vaultclient.LoadSecretData(appRoleConfig, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Act
+	_, err = vaultclient.LoadSecretData(appRoleConfig, log)
+
+	//Assert
+	if err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Error("Expected an already used wrapping token error, got ", err)
+	}
+}
+
+func Test_VaultClient_GivenWrappingTokenOfAnotherResponse_ReturnsError(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"TEST_KEY": "TEST_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+	roleId, _ := setupTestAppRole(t, clientConfig, "1h", "2h")
+
+	conf := api.DefaultConfig()
+	conf.Address = clientConfig.Address
+	rootClient := createVaultClient(t, clientConfig.Namespace, conf, clientConfig.AuthToken)
+	wrapped, err := rootClient.Logical().Write("sys/wrapping/wrap", map[string]interface{}{"secret_id": "forged"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appRoleConfig := clientConfig
+	appRoleConfig.AuthMethod = "approle"
+	appRoleConfig.AppRoleId = roleId
+	appRoleConfig.WrappedSecretId = wrapped.WrapInfo.Token
+
+	//Act
+	_, err = vaultclient.LoadSecretData(appRoleConfig, log)
+
+	//Assert
+	if err == nil || !strings.Contains(err.Error(), "not by an approle secret id request") {
+		t.Error("Expected a wrapping token origin error, got ", err)
+	}
+}
+
+func Test_VaultClient_GivenEmptyWrappingTokenLookup_ReturnsError(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	server := httptest.NewServer(nethttp.HandlerFunc(func(writer nethttp.ResponseWriter, request *nethttp.Request) {
+		writer.WriteHeader(nethttp.StatusNoContent)
+	}))
+	defer server.Close()
+
+	appRoleConfig := vaultclient.VaultConfig{
+		Address:         server.URL,
+		AuthMethod:      "approle",
+		AppRoleId:       "role-id",
+		WrappedSecretId: "wrapping-token",
+		EngineName:      "secret",
+		SecretPath:      "config",
+	}
+
+	//Act
+	_, err := vaultclient.LoadSecretData(appRoleConfig, log)
+
+	//Assert
+	if err == nil || !strings.Contains(err.Error(), "lookup response is empty") {
+		t.Error("Expected an empty wrapping token lookup error, got ", err)
+	}
+}
+
+func Test_WhenAuthMethodIsAppRole_GivenSecretIdAndWrappedSecretId_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:         "http://",
+		app.VaultEngine:          "test-engine",
+		app.VaultSecretPath:      "test-path",
+		app.Namespace:            "test-namespace",
+		app.Kubeconfig:           "test-kubeconfig",
+		app.ObjectNameToApply:    "test-secret",
+		app.VaultAuthMethod:      "approle",
+		app.VaultAppRoleId:       "role-id",
+		app.VaultAppRoleSecretId: "secret-id",
+		app.VaultAppRoleWrapped:  "wrapping-token",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil || err.Error() != "Vault SecretId and wrapped SecretId cannot be used together" {
+		t.Error("Expected exclusive secret id error, got ", err)
+	}
+}
+
+func Test_VaultClient_GivenAppRoleAtCustomMount_LogsInAtMount(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"TEST_KEY": "TEST_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+	roleId, secretId := setupTestAppRoleAtMount(t, clientConfig, "teams/team-a/approle", "1h", "2h")
+
+	appRoleConfig := clientConfig
+	appRoleConfig.AuthMethod = "approle"
+	appRoleConfig.AuthMount = "teams/team-a/approle"
+	appRoleConfig.AppRoleId = roleId
+	appRoleConfig.SecretId = secretId
+	appRoleConfig.KvVersion = vaultclient.KvVersion2
+
+	//Act
+	secretData, err := vaultclient.LoadSecretData(appRoleConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secretData["TEST_KEY"] != "TEST_VALUE" {
+		t.Error("Expected secret data to contain TEST_KEY with value TEST_VALUE")
+	}
+}
+
+func Test_VaultClient_GivenAppRoleAtCustomMountWithoutAuthMount_ReturnsError(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"TEST_KEY": "TEST_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+	roleId, secretId := setupTestAppRoleAtMount(t, clientConfig, "teams/team-a/approle", "1h", "2h")
+
+	appRoleConfig := clientConfig
+	appRoleConfig.AuthMethod = "approle"
+	appRoleConfig.AppRoleId = roleId
+	appRoleConfig.SecretId = secretId
+
+	//Act
+	_, err := vaultclient.LoadSecretData(appRoleConfig, log)
+
+	//Assert
+	if err == nil {
+		t.Error("Expected the login at the default approle mount to fail")
+	}
+}
+
+func Test_VaultClient_GivenGithubAuthAtCustomMount_LogsInAtMount(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	fakeVault := createFakeVaultWithLogin(t, "auth/github-org-a/login", map[string]string{
+		"token": "github-token",
+	}, "application/data/dev/config", map[string]interface{}{"TEST_KEY": "TEST_VALUE"})
+
+	clientConfig := getTestVaultConfigWithAuthMethod("github")
+	clientConfig.Address = fakeVault.URL
+	clientConfig.AuthMount = "/github-org-a/"
+	clientConfig.GithubToken = "github-token"
+
+	//Act
+	secretData, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secretData["TEST_KEY"] != "TEST_VALUE" {
+		t.Error("Expected secret data to contain TEST_KEY with value TEST_VALUE")
+	}
+}
+
+func Test_GivenInvalidAuthMount_ReturnsError(t *testing.T) {
+	for _, mount := range []string{"../sys", "teams//approle", "github org"} {
+		t.Run(mount, func(t *testing.T) {
+			//Arrange
+			commandArgs := map[string]string{
+				app.VaultAddress:      "http://",
+				app.VaultToken:        "test-token",
+				app.VaultEngine:       "test-engine",
+				app.VaultSecretPath:   "test-path",
+				app.Namespace:         "test-namespace",
+				app.Kubeconfig:        "test-kubeconfig",
+				app.ObjectNameToApply: "test-secret",
+				app.VaultAuthMethod:   "token",
+				app.VaultAuthMount:    mount,
+			}
+
+			//Act
+			_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+			//Assert
+			if err == nil || !strings.HasPrefix(err.Error(), "Vault auth mount must be a path") {
+				t.Error("Expected invalid auth mount error, got ", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package tests
+
+import (
+	"k8s-from-secrets-vault/app"
+	vaultclient "k8s-from-secrets-vault/vault"
+	"testing"
+)
+
+func Test_VaultClient_GivenKvV1Engine_DetectsVersionAndLoadsSecretData(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	expectedSecretData := map[string]interface{}{
+		"TEST_KEY": "TEST_VALUE",
+		"data":     "NOT_A_KV2_WRAPPER",
+	}
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndKvVersion(t, expectedSecretData, vaultclient.KvVersion1)
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	//Act
+	secretData, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secretData) != len(expectedSecretData) {
+		t.Errorf("Expected %d keys, got %d", len(expectedSecretData), len(secretData))
+	}
+	for key, value := range expectedSecretData {
+		if secretData[key] != value {
+			t.Errorf("Expected secret data to contain value for %s", key)
+		}
+	}
+}
+
+func Test_VaultClient_GivenKvV2Engine_DetectsVersionAndLoadsSecretData(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	expectedSecretData := map[string]interface{}{
+		"TEST_KEY": "TEST_VALUE",
+	}
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndKvVersion(t, expectedSecretData, vaultclient.KvVersion2)
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	//Act
+	secretData, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secretData) != 1 || secretData["TEST_KEY"] != "TEST_VALUE" {
+		t.Errorf("Expected only TEST_KEY with value TEST_VALUE, got %v", secretData)
+	}
+}
+
+func Test_VaultClient_GivenExplicitKvVersion_SkipsDetection(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	expectedSecretData := map[string]interface{}{
+		"TEST_KEY": "TEST_VALUE",
+	}
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndKvVersion(t, expectedSecretData, vaultclient.KvVersion1)
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	clientConfig.KvVersion = vaultclient.KvVersion2
+
+	//Act
+	secretData, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secretData) != 0 {
+		t.Errorf("Expected secret to be read from the KVv2 data path, got %v", secretData)
+	}
+}
+
+func Test_GivenInvalidKvVersion_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:      "http://",
+		app.VaultToken:        "test-token",
+		app.VaultEngine:       "test-engine",
+		app.VaultSecretPath:   "test-path",
+		app.VaultKvVersion:    "3",
+		app.Namespace:         "test-namespace",
+		app.ApplyAsConfigmap:  "false",
+		app.Kubeconfig:        "test-kubeconfig",
+		app.ObjectNameToApply: "test-secret",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if err.Error() != "Vault KV version must be 1 or 2" {
+		t.Error("Expected error to be 'Vault KV version must be 1 or 2'")
+	}
+}
+
+func Test_VaultClient_GivenSecretVersion_LoadsPinnedVersion(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"TEST_KEY": "FIRST"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	writeTestSecret(t, clientConfig, map[string]interface{}{"TEST_KEY": "SECOND"})
+
+	clientConfig.Version = 1
+
+	//Act
+	secret, err := vaultclient.LoadSecret(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["TEST_KEY"] != "FIRST" {
+		t.Errorf("Expected TEST_KEY from version 1, got %s", secret.Data["TEST_KEY"])
+	}
+	if secret.Version != 1 {
+		t.Errorf("Expected loaded version to be 1, got %d", secret.Version)
+	}
+}
+
+func Test_VaultClient_GivenNoSecretVersion_LoadsLatestVersion(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"TEST_KEY": "FIRST"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	writeTestSecret(t, clientConfig, map[string]interface{}{"TEST_KEY": "SECOND"})
+
+	//Act
+	secret, err := vaultclient.LoadSecret(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["TEST_KEY"] != "SECOND" || secret.Version != 2 {
+		t.Errorf("Expected TEST_KEY from version 2, got %s from version %d", secret.Data["TEST_KEY"], secret.Version)
+	}
+}
+
+func Test_VaultClient_GivenMissingSecretVersion_ReturnsError(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"TEST_KEY": "FIRST"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	clientConfig.Version = 5
+
+	//Act
+	_, err := vaultclient.LoadSecret(clientConfig, log)
+
+	//Assert
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if err.Error() != "secret version 5 does not exist" {
+		t.Errorf("Expected error to be 'secret version 5 does not exist', got '%v'", err)
+	}
+}
+
+func Test_GivenNonNumericSecretVersion_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:       "http://",
+		app.VaultToken:         "test-token",
+		app.VaultEngine:        "test-engine",
+		app.VaultSecretPath:    "test-path",
+		app.VaultSecretVersion: "latest",
+		app.Namespace:          "test-namespace",
+		app.ApplyAsConfigmap:   "false",
+		app.Kubeconfig:         "test-kubeconfig",
+		app.ObjectNameToApply:  "test-secret",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if err.Error() != "Vault secret version must be a positive number" {
+		t.Error("Expected error to be 'Vault secret version must be a positive number'")
+	}
+}
+
+func Test_VaultClient_GivenMultipleSources_MergesInOrderWithLastWins(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{
+		"SHARED_KEY": "FROM_CONFIG",
+		"CONFIG_KEY": "CONFIG_VALUE",
+	})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	writeTestSecretToPath(t, clientConfig, "shared", "dev/database", map[string]interface{}{
+		"SHARED_KEY":   "FROM_DATABASE",
+		"DATABASE_KEY": "DATABASE_VALUE",
+	})
+
+	clientConfig.Sources = vaultclient.ParseSecretSources("dev/config,\nshared:dev/database", clientConfig.EngineName)
+
+	//Act
+	secretData, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSecretData := map[string]string{
+		"SHARED_KEY":   "FROM_DATABASE",
+		"CONFIG_KEY":   "CONFIG_VALUE",
+		"DATABASE_KEY": "DATABASE_VALUE",
+	}
+	for key, value := range expectedSecretData {
+		if secretData[key] != value {
+			t.Errorf("Expected %s to be %s, got %s", key, value, secretData[key])
+		}
+	}
+}
+
+func Test_VaultClient_GivenMultipleSourcesWithFirstWins_KeepsFirstValue(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"SHARED_KEY": "FIRST"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	writeTestSecretToPath(t, clientConfig, clientConfig.EngineName, "dev/overrides", map[string]interface{}{"SHARED_KEY": "SECOND"})
+
+	clientConfig.Sources = vaultclient.ParseSecretSources("dev/config,dev/overrides", clientConfig.EngineName)
+	clientConfig.MergeStrategy = vaultclient.MergeFirstWins
+
+	//Act
+	secretData, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secretData["SHARED_KEY"] != "FIRST" {
+		t.Errorf("Expected SHARED_KEY to be FIRST, got %s", secretData["SHARED_KEY"])
+	}
+}
+
+func Test_VaultClient_GivenMultipleSourcesWithFailStrategy_ReturnsErrorOnDuplicateKey(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"SHARED_KEY": "FIRST"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	writeTestSecretToPath(t, clientConfig, clientConfig.EngineName, "dev/overrides", map[string]interface{}{"SHARED_KEY": "SECOND"})
+
+	clientConfig.Sources = vaultclient.ParseSecretSources("dev/config,dev/overrides", clientConfig.EngineName)
+	clientConfig.MergeStrategy = vaultclient.MergeFail
+
+	//Act
+	_, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if err.Error() != "key SHARED_KEY from application:dev/overrides is already defined by a previous path" {
+		t.Errorf("Expected duplicate key error, got '%v'", err)
+	}
+}
+
+func Test_GivenSecretVersionWithMultiplePaths_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:       "http://",
+		app.VaultToken:         "test-token",
+		app.VaultEngine:        "test-engine",
+		app.VaultSecretPath:    "dev/config,dev/database",
+		app.VaultSecretVersion: "2",
+		app.Namespace:          "test-namespace",
+		app.ApplyAsConfigmap:   "false",
+		app.Kubeconfig:         "test-kubeconfig",
+		app.ObjectNameToApply:  "test-secret",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if err.Error() != "Vault secret version can only be pinned for a single secret path" {
+		t.Errorf("Expected error to be 'Vault secret version can only be pinned for a single secret path', got '%v'", err)
+	}
+}
@@ -1,13 +1,34 @@
 package tests
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	kv "github.com/hashicorp/vault-plugin-secrets-kv"
 	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/builtin/credential/approle"
+	"github.com/hashicorp/vault/builtin/credential/cert"
+	"github.com/hashicorp/vault/builtin/credential/userpass"
+	"github.com/hashicorp/vault/builtin/logical/pki"
 	"github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/hashicorp/vault/vault"
 	vaultclient "k8s-from-secrets-vault/vault"
+	"math/big"
 	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t *testing.T, secretsToWrite map[string]interface{}) (vaultclient.VaultConfig, net.Listener) {
@@ -24,10 +45,19 @@ func getClientConfigForNewTestVaultWithSecretsAndGithubAuth(t *testing.T, secret
 	return clientConfig, vaultHttpListener
 }
 
+func getClientConfigForNewTestVaultWithSecretsAndKvVersion(t *testing.T, secretsToWrite map[string]interface{}, kvVersion string) (vaultclient.VaultConfig, net.Listener) {
+	t.Helper()
+	testVaultConfig := getTestVaultConfigWithAuthMethod("token")
+	testVaultConfig.KvVersion = kvVersion
+	vaultHttpListener, clientConfig := getClientConfigWithSecrets(t, secretsToWrite, testVaultConfig)
+	return clientConfig, vaultHttpListener
+}
+
 func getTestVaultConfigWithAuthMethod(authMethod string) vaultclient.VaultConfig {
 	testVaultConfig := vaultclient.VaultConfig{
 		EngineName: "application",
 		SecretPath: "dev/config",
+		KvVersion:  vaultclient.KvVersion2,
 		Namespace:  "",
 		AuthMethod: authMethod,
 	}
@@ -55,7 +85,7 @@ func getClientConfigWithSecrets(t *testing.T, secretsToWrite map[string]interfac
 
 func createTestVaultWithSecrets(t *testing.T, vaultConfig vaultclient.VaultConfig, secretPath string, testSecrets map[string]interface{}) (net.Listener, string, string) {
 	t.Helper()
-	cluster := vault.NewTestCluster(t, &vault.CoreConfig{}, &vault.TestClusterOptions{NumCores: 1})
+	cluster := createTestVaultCluster(t)
 	rootToken := cluster.RootToken
 	vaultCore := cluster.Cores[0].Core
 
@@ -69,21 +99,34 @@ func createTestVaultWithSecrets(t *testing.T, vaultConfig vaultclient.VaultConfi
 
 	client = client.WithNamespace(vaultConfig.Namespace)
 
-	createSecretEngineIfMissing(t, client, vaultConfig.EngineName)
+	createSecretEngineIfMissing(t, client, vaultConfig.EngineName, vaultConfig.KvVersion)
 
-	setupTestSecrets(t, client, secretPath, testSecrets)
+	setupTestSecrets(t, client, secretPath, vaultConfig.KvVersion, testSecrets)
 
 	return httpServerListener, rootToken, serverAddress
 }
 
-func setupTestSecrets(t *testing.T, client *api.Client, secretPath string, testSecrets map[string]interface{}) {
+func setupTestSecrets(t *testing.T, client *api.Client, secretPath string, kvVersion string, testSecrets map[string]interface{}) {
 	t.Helper()
 
 	if len(testSecrets) == 0 {
 		return
 	}
 
-	_, err := client.Logical().Write(secretPath, testSecrets)
+	secretToWrite := testSecrets
+	if kvVersion == vaultclient.KvVersion2 {
+		secretToWrite = map[string]interface{}{"data": testSecrets}
+	}
+
+	// A freshly mounted KVv2 engine rejects writes until its storage upgrade finishes
+	var err error
+	for attempt := 0; attempt < 50; attempt++ {
+		_, err = client.Logical().Write(secretPath, secretToWrite)
+		if err == nil || !strings.Contains(err.Error(), "upgrad") {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -93,12 +136,12 @@ func setupTestSecrets(t *testing.T, client *api.Client, secretPath string, testS
 	}
 }
 
-func createSecretEngineIfMissing(t *testing.T, client *api.Client, engineName string) {
+func createSecretEngineIfMissing(t *testing.T, client *api.Client, engineName string, kvVersion string) {
 	t.Helper()
 
-	err := client.Sys().Mount(engineName, &api.MountInput{Type: "kv"})
+	err := client.Sys().Mount(engineName, &api.MountInput{Type: "kv", Options: map[string]string{"version": kvVersion}})
 
-	if err != nil && !strings.Contains(err.Error(), "existing mount") {
+	if err != nil && !strings.Contains(err.Error(), "existing mount") && !strings.Contains(err.Error(), "already in use") {
 		t.Fatal(err)
 	}
 
@@ -130,3 +173,373 @@ func destroyVaultHttpListener(t *testing.T, vaultHttpListener net.Listener) {
 		t.Error(err)
 	}
 }
+
+const fakeVaultClientToken = "fake-client-token"
+
+// createFakeVaultWithLogin serves a minimal Vault API that accepts a single login and a single KV v2 secret read
+func createFakeVaultWithLogin(t *testing.T, loginPath string, expectedLogin map[string]string, secretPath string, secretData map[string]interface{}) *httptest.Server {
+	t.Helper()
+
+	mux := nethttp.NewServeMux()
+	mux.HandleFunc("/v1/"+strings.TrimPrefix(loginPath, "/"), func(writer nethttp.ResponseWriter, request *nethttp.Request) {
+		var body map[string]string
+		err := json.NewDecoder(request.Body).Decode(&body)
+		if err != nil || request.Method != nethttp.MethodPut && request.Method != nethttp.MethodPost {
+			writeFakeVaultResponse(writer, nethttp.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid request"}})
+			return
+		}
+		for key, value := range expectedLogin {
+			if body[key] != value {
+				writeFakeVaultResponse(writer, nethttp.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid " + key}})
+				return
+			}
+		}
+		writeFakeVaultResponse(writer, nethttp.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": fakeVaultClientToken},
+		})
+	})
+	mux.HandleFunc("/v1/sys/internal/ui/mounts/", func(writer nethttp.ResponseWriter, request *nethttp.Request) {
+		writeFakeVaultResponse(writer, nethttp.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"type": "kv", "options": map[string]string{"version": "2"}},
+		})
+	})
+	mux.HandleFunc("/v1/"+secretPath, func(writer nethttp.ResponseWriter, request *nethttp.Request) {
+		if request.Header.Get("X-Vault-Token") != fakeVaultClientToken {
+			writeFakeVaultResponse(writer, nethttp.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+		writeFakeVaultResponse(writer, nethttp.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"data": secretData},
+		})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// createFakeGithubOidcProvider serves the Github Actions OIDC token request endpoint
+func createFakeGithubOidcProvider(t *testing.T, requestToken string, audience string, jwt string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(nethttp.HandlerFunc(func(writer nethttp.ResponseWriter, request *nethttp.Request) {
+		if request.Header.Get("Authorization") != "bearer "+requestToken {
+			writer.WriteHeader(nethttp.StatusUnauthorized)
+			return
+		}
+		if request.URL.Query().Get("audience") != audience {
+			writer.WriteHeader(nethttp.StatusBadRequest)
+			return
+		}
+		writeFakeVaultResponse(writer, nethttp.StatusOK, map[string]interface{}{"value": jwt})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeFakeVaultResponse(writer nethttp.ResponseWriter, status int, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(body)
+}
+
+// writeTestSecret writes the secret of the given client config again, creating a new KVv2 version
+func writeTestSecret(t *testing.T, clientConfig vaultclient.VaultConfig, testSecrets map[string]interface{}) {
+	t.Helper()
+	writeTestSecretToPath(t, clientConfig, clientConfig.EngineName, clientConfig.SecretPath, testSecrets)
+}
+
+// writeTestSecretToPath writes a secret to a KVv2 engine of the test vault, mounting the engine when missing
+func writeTestSecretToPath(t *testing.T, clientConfig vaultclient.VaultConfig, engineName string, secretPath string, testSecrets map[string]interface{}) {
+	t.Helper()
+
+	conf := api.DefaultConfig()
+	conf.Address = clientConfig.Address
+	client := createVaultClient(t, clientConfig.Namespace, conf, clientConfig.AuthToken)
+
+	createSecretEngineIfMissing(t, client, engineName, vaultclient.KvVersion2)
+
+	testVaultConfig := vaultclient.VaultConfig{EngineName: engineName, SecretPath: secretPath, KvVersion: vaultclient.KvVersion2}
+	setupTestSecrets(t, client, vaultclient.GetSecretPath(testVaultConfig), vaultclient.KvVersion2, testSecrets)
+}
+
+// setupTestPki mounts a PKI engine with a generated root CA and a role allowing any name below example.com
+func setupTestPki(t *testing.T, clientConfig vaultclient.VaultConfig, engineName string, role string) {
+	t.Helper()
+
+	conf := api.DefaultConfig()
+	conf.Address = clientConfig.Address
+	client := createVaultClient(t, clientConfig.Namespace, conf, clientConfig.AuthToken)
+
+	err := client.Sys().Mount(engineName, &api.MountInput{Type: "pki", Config: api.MountConfigInput{MaxLeaseTTL: "87600h"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Logical().Write(engineName+"/root/generate/internal", map[string]interface{}{"common_name": "example.com", "ttl": "87600h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Logical().Write(engineName+"/roles/"+role, map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+		"max_ttl":          "720h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setupTestAppRole enables AppRole auth with a role able to read every secret, returning its role id and a secret id
+func setupTestAppRole(t *testing.T, clientConfig vaultclient.VaultConfig, tokenTtl string, tokenMaxTtl string) (string, string) {
+	t.Helper()
+	return setupTestAppRoleAtMount(t, clientConfig, "approle", tokenTtl, tokenMaxTtl)
+}
+
+func setupTestAppRoleAtMount(t *testing.T, clientConfig vaultclient.VaultConfig, mount string, tokenTtl string, tokenMaxTtl string) (string, string) {
+	t.Helper()
+
+	conf := api.DefaultConfig()
+	conf.Address = clientConfig.Address
+	client := createVaultClient(t, clientConfig.Namespace, conf, clientConfig.AuthToken)
+
+	err := client.Sys().EnableAuthWithOptions(mount, &api.EnableAuthOptions{Type: "approle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTestReadAllPolicy(t, client)
+	_, err = client.Logical().Write("auth/"+mount+"/role/test", map[string]interface{}{
+		"token_policies": "read-all",
+		"token_ttl":      tokenTtl,
+		"token_max_ttl":  tokenMaxTtl,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roleId, err := client.Logical().Read("auth/" + mount + "/role/test/role-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretId, err := client.Logical().Write("auth/"+mount+"/role/test/secret-id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return roleId.Data["role_id"].(string), secretId.Data["secret_id"].(string)
+}
+
+// setupTestUserpass enables userpass auth at the given mount with a user able to read every secret
+func setupTestUserpass(t *testing.T, clientConfig vaultclient.VaultConfig, mount string, username string, password string) {
+	t.Helper()
+
+	conf := api.DefaultConfig()
+	conf.Address = clientConfig.Address
+	client := createVaultClient(t, clientConfig.Namespace, conf, clientConfig.AuthToken)
+
+	err := client.Sys().EnableAuthWithOptions(mount, &api.EnableAuthOptions{Type: "userpass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTestReadAllPolicy(t, client)
+	_, err = client.Logical().Write("auth/"+mount+"/users/"+username, map[string]interface{}{
+		"password":       password,
+		"token_policies": "read-all",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wrapTestAppRoleSecretId issues a secret id for the role of setupTestAppRole, returned as a response wrapping token
+func wrapTestAppRoleSecretId(t *testing.T, clientConfig vaultclient.VaultConfig) string {
+	t.Helper()
+
+	conf := api.DefaultConfig()
+	conf.Address = clientConfig.Address
+	client := createVaultClient(t, clientConfig.Namespace, conf, clientConfig.AuthToken)
+	client.SetWrappingLookupFunc(func(operation, path string) string { return "5m" })
+
+	secret, err := client.Logical().Write("auth/approle/role/test/secret-id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == nil || secret.WrapInfo == nil {
+		t.Fatal("Expected a wrapped secret id response")
+	}
+	return secret.WrapInfo.Token
+}
+
+// createTestReadAllPolicy creates the read-all policy, granting read access to every path
+func createTestReadAllPolicy(t *testing.T, client *api.Client) {
+	t.Helper()
+
+	err := client.Sys().PutPolicy("read-all", `path "*" { capabilities = ["read", "list"] }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setupTestCertAuth enables TLS certificate auth with a role trusting the given certificate to read every secret
+func setupTestCertAuth(t *testing.T, client *api.Client, role string, certificate testTlsCertificate) {
+	t.Helper()
+
+	err := client.Sys().EnableAuthWithOptions("cert", &api.EnableAuthOptions{Type: "cert"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTestReadAllPolicy(t, client)
+	_, err = client.Logical().Write("auth/cert/certs/"+role, map[string]interface{}{
+		"certificate":    certificate.certPem,
+		"token_policies": "read-all",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// countTestVaultTokens returns the number of live tokens, listed through their accessors
+func countTestVaultTokens(t *testing.T, clientConfig vaultclient.VaultConfig) int {
+	t.Helper()
+
+	conf := api.DefaultConfig()
+	conf.Address = clientConfig.Address
+	client := createVaultClient(t, clientConfig.Namespace, conf, clientConfig.AuthToken)
+
+	secret, err := client.Logical().List("auth/token/accessors")
+	if err != nil || secret == nil {
+		t.Fatal("Failed to list token accessors ", err)
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	return len(keys)
+}
+
+func createTestVaultCluster(t *testing.T) *vault.TestCluster {
+	t.Helper()
+	return vault.NewTestCluster(t, &vault.CoreConfig{
+		LogicalBackends: map[string]logical.Factory{"kv": kv.Factory, "pki": pki.Factory},
+		CredentialBackends: map[string]logical.Factory{
+			"approle":  approle.Factory,
+			"cert":     cert.Factory,
+			"userpass": userpass.Factory,
+		},
+	}, &vault.TestClusterOptions{NumCores: 1})
+}
+
+// testTlsCertificate is a self-signed certificate written to disk, so it can act as its own CA
+type testTlsCertificate struct {
+	certificate tls.Certificate
+	certPem     string
+	certPath    string
+	keyPath     string
+}
+
+// generateTestCertificate returns a PEM encoded self-signed certificate for the DNS name and its private key, the
+// certificate can act as its own CA for server and client authentication
+func generateTestCertificate(t *testing.T, dnsName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: dnsName},
+		DNSNames:              []string{dnsName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateKey}))
+}
+
+// writeTestTlsCertificate writes a certificate from generateTestCertificate and its key to disk
+func writeTestTlsCertificate(t *testing.T, dnsName string) testTlsCertificate {
+	t.Helper()
+
+	certPem, keyPem := generateTestCertificate(t, dnsName)
+	keyPair, err := tls.X509KeyPair([]byte(certPem), []byte(keyPem))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	directory := t.TempDir()
+	certPath := filepath.Join(directory, "cert.pem")
+	keyPath := filepath.Join(directory, "key.pem")
+	if err = os.WriteFile(certPath, []byte(certPem), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyPath, []byte(keyPem), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return testTlsCertificate{certificate: keyPair, certPem: certPem, certPath: certPath, keyPath: keyPath}
+}
+
+// createTestTlsVaultWithSecrets serves a test Vault over TLS with a certificate for localhost, requiring a client
+// certificate signed by clientCa when one is given
+func createTestTlsVaultWithSecrets(t *testing.T, clientCa *testTlsCertificate, testSecrets map[string]interface{}) (vaultclient.VaultConfig, testTlsCertificate) {
+	t.Helper()
+
+	testVaultConfig := getTestVaultConfigWithAuthMethod("token")
+	cluster := createTestVaultCluster(t)
+	serverCertificate := writeTestTlsCertificate(t, "localhost")
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{serverCertificate.certificate}}
+	if clientCa != nil {
+		clientCas := x509.NewCertPool()
+		clientCas.AppendCertsFromPEM([]byte(clientCa.certPem))
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.ClientCAs = clientCas
+	}
+
+	listener, _ := http.TestListener(t)
+	port := listener.Addr().(*net.TCPAddr).Port
+	address := fmt.Sprintf("https://localhost:%d", port)
+	http.TestServerWithListener(t, tls.NewListener(listener, tlsConfig), address, cluster.Cores[0].Core)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	clientConfig := testVaultConfig
+	clientConfig.Address = address
+	clientConfig.AuthToken = cluster.RootToken
+
+	client := createTestTlsRootClient(t, clientConfig, serverCertificate, clientCa)
+	createSecretEngineIfMissing(t, client, testVaultConfig.EngineName, testVaultConfig.KvVersion)
+	setupTestSecrets(t, client, vaultclient.GetSecretPath(testVaultConfig), testVaultConfig.KvVersion, testSecrets)
+
+	return clientConfig, serverCertificate
+}
+
+// createTestTlsRootClient returns a root client trusting the test server and presenting the client certificate when
+// one is required
+func createTestTlsRootClient(t *testing.T, clientConfig vaultclient.VaultConfig, serverCertificate testTlsCertificate, clientCertificate *testTlsCertificate) *api.Client {
+	t.Helper()
+
+	conf := api.DefaultConfig()
+	conf.Address = clientConfig.Address
+	tlsConfig := &api.TLSConfig{CACertBytes: []byte(serverCertificate.certPem)}
+	if clientCertificate != nil {
+		tlsConfig.ClientCert = clientCertificate.certPath
+		tlsConfig.ClientKey = clientCertificate.keyPath
+	}
+	if err := conf.ConfigureTLS(tlsConfig); err != nil {
+		t.Fatal(err)
+	}
+	return createVaultClient(t, clientConfig.Namespace, conf, clientConfig.AuthToken)
+}
@@ -0,0 +1,331 @@
+package tests
+
+import (
+	"context"
+	"k8s-from-secrets-vault/app"
+	kubernetes "k8s-from-secrets-vault/kubernetes"
+	vaultclient "k8s-from-secrets-vault/vault"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_VaultClient_GivenInlineCaCert_LoadsSecretOverTls(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+	clientConfig, serverCertificate := createTestTlsVaultWithSecrets(t, nil, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	clientConfig.CaCert = serverCertificate.certPem
+
+	//Act
+	data, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["CONFIG_KEY"] != "CONFIG_VALUE" {
+		t.Errorf("Expected CONFIG_KEY to be CONFIG_VALUE, got %s", data["CONFIG_KEY"])
+	}
+}
+
+func Test_VaultClient_GivenCaCertPath_LoadsSecretOverTls(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+	clientConfig, serverCertificate := createTestTlsVaultWithSecrets(t, nil, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	clientConfig.CaCert = serverCertificate.certPath
+
+	//Act
+	data, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["CONFIG_KEY"] != "CONFIG_VALUE" {
+		t.Errorf("Expected CONFIG_KEY to be CONFIG_VALUE, got %s", data["CONFIG_KEY"])
+	}
+}
+
+func Test_VaultClient_GivenCaPath_LoadsSecretOverTls(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+	clientConfig, serverCertificate := createTestTlsVaultWithSecrets(t, nil, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	caPath := t.TempDir()
+	err := os.WriteFile(filepath.Join(caPath, "vault-ca.pem"), []byte(serverCertificate.certPem), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig.CaPath = caPath
+
+	//Act
+	data, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["CONFIG_KEY"] != "CONFIG_VALUE" {
+		t.Errorf("Expected CONFIG_KEY to be CONFIG_VALUE, got %s", data["CONFIG_KEY"])
+	}
+}
+
+func Test_VaultClient_GivenUntrustedServerCertificate_ReturnsError(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+	clientConfig, _ := createTestTlsVaultWithSecrets(t, nil, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+
+	//Act
+	_, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Error("Expected a certificate verification error, got ", err)
+	}
+}
+
+func Test_VaultClient_GivenTlsSkipVerify_LoadsSecretFromUntrustedServer(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+	clientConfig, _ := createTestTlsVaultWithSecrets(t, nil, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	clientConfig.TlsSkipVerify = true
+
+	//Act
+	data, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["CONFIG_KEY"] != "CONFIG_VALUE" {
+		t.Errorf("Expected CONFIG_KEY to be CONFIG_VALUE, got %s", data["CONFIG_KEY"])
+	}
+}
+
+func Test_VaultClient_GivenCaCertAndVaultSkipVerifyEnvironment_VerifiesAgainstTheCaCert(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+	clientConfig, _ := createTestTlsVaultWithSecrets(t, nil, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	clientConfig.CaCert = writeTestTlsCertificate(t, "unknown-ca").certPem
+	t.Setenv("VAULT_SKIP_VERIFY", "true")
+
+	//Act
+	_, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err == nil {
+		t.Error("Expected the configured CA to win over the skip verify of the environment")
+	}
+}
+
+func Test_VaultClient_GivenTlsServerName_VerifiesCertificateAgainstIt(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+	clientConfig, serverCertificate := createTestTlsVaultWithSecrets(t, nil, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	clientConfig.CaCert = serverCertificate.certPem
+	clientConfig.Address = strings.Replace(clientConfig.Address, "localhost", "127.0.0.1", 1)
+
+	//Act
+	_, errWithoutServerName := vaultclient.LoadSecretData(clientConfig, log)
+	clientConfig.TlsServerName = "localhost"
+	data, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if errWithoutServerName == nil {
+		t.Error("Expected the certificate to be rejected for the IP address")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["CONFIG_KEY"] != "CONFIG_VALUE" {
+		t.Errorf("Expected CONFIG_KEY to be CONFIG_VALUE, got %s", data["CONFIG_KEY"])
+	}
+}
+
+func Test_VaultClient_GivenClientCertificate_LoadsSecretOverMutualTls(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+	clientCertificate := writeTestTlsCertificate(t, "vault-client")
+	clientConfig, serverCertificate := createTestTlsVaultWithSecrets(t, &clientCertificate, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	clientConfig.CaCert = serverCertificate.certPem
+
+	//Act
+	_, errWithoutClientCertificate := vaultclient.LoadSecretData(clientConfig, log)
+	clientConfig.ClientCert = clientCertificate.certPath
+	clientConfig.ClientKey = clientCertificate.keyPath
+	data, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if errWithoutClientCertificate == nil {
+		t.Error("Expected the server to reject a client without certificate")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["CONFIG_KEY"] != "CONFIG_VALUE" {
+		t.Errorf("Expected CONFIG_KEY to be CONFIG_VALUE, got %s", data["CONFIG_KEY"])
+	}
+}
+
+func Test_GivenClientCertWithoutKey_ReturnsError(t *testing.T) {
+	//Arrange
+	config := vaultclient.VaultConfig{
+		Address:    "https://localhost:8200",
+		AuthMethod: "token",
+		AuthToken:  "token",
+		EngineName: "application",
+		SecretPath: "dev/config",
+		ClientCert: "/etc/vault/client.pem",
+	}
+
+	//Act
+	err := vaultclient.CheckVaultConfigRequiredFields(config)
+
+	//Assert
+	if err == nil || err.Error() != "clientCert and clientKey must be set together" {
+		t.Error("Expected missing client key error, got ", err)
+	}
+}
+
+func Test_Command_GivenTlsSettings_AppliesSecretFromTlsVault(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+	clientCertificate := writeTestTlsCertificate(t, "vault-client")
+	vaultClientConfig, serverCertificate := createTestTlsVaultWithSecrets(t, &clientCertificate, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+
+	parameters := getFakeKubernetesParameters(t)
+	config, err := kubernetes.CreateConfig(parameters, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, fakeClient, err := getFakeKubernetesClient(config, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	command, err := app.SetupCommandWithKubernetesClient(map[string]string{
+		app.VaultAddress:      vaultClientConfig.Address,
+		app.VaultToken:        vaultClientConfig.AuthToken,
+		app.VaultEngine:       vaultClientConfig.EngineName,
+		app.VaultSecretPath:   vaultClientConfig.SecretPath,
+		app.VaultCaCert:       serverCertificate.certPem,
+		app.VaultClientCert:   clientCertificate.certPath,
+		app.VaultClientKey:    clientCertificate.keyPath,
+		app.Kubeconfig:        parameters.Base64Kubeconfig,
+		app.Namespace:         parameters.Namespace,
+		app.ObjectNameToApply: "test-secret",
+		app.VaultAuthMethod:   "token",
+	}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Act
+	err = command.Execute()
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.StringData["CONFIG_KEY"] != "CONFIG_VALUE" {
+		t.Errorf("Expected CONFIG_KEY to be CONFIG_VALUE, got %s", secret.StringData["CONFIG_KEY"])
+	}
+}
+
+func Test_GivenTlsClientCertWithoutKey_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:      "https://localhost:8200",
+		app.VaultToken:        "test-token",
+		app.VaultEngine:       "test-engine",
+		app.VaultSecretPath:   "test-path",
+		app.VaultClientCert:   "/etc/vault/client.pem",
+		app.Kubeconfig:        "test-kubeconfig",
+		app.Namespace:         "test-namespace",
+		app.ObjectNameToApply: "test-secret",
+		app.VaultAuthMethod:   "token",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil || err.Error() != "Vault TLS client certificate and key must be set together" {
+		t.Error("Expected missing client key error, got ", err)
+	}
+}
+
+func Test_VaultClient_GivenCertAuth_LogsInWithClientCertificate(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+	clientCertificate := writeTestTlsCertificate(t, "vault-client")
+	clientConfig, serverCertificate := createTestTlsVaultWithSecrets(t, &clientCertificate, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	setupTestCertAuth(t, createTestTlsRootClient(t, clientConfig, serverCertificate, &clientCertificate), "runner", clientCertificate)
+
+	clientConfig.AuthMethod = "cert"
+	clientConfig.AuthToken = ""
+	clientConfig.AuthRole = "runner"
+	clientConfig.CaCert = serverCertificate.certPem
+	clientConfig.ClientCert = clientCertificate.certPath
+	clientConfig.ClientKey = clientCertificate.keyPath
+
+	//Act
+	data, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["CONFIG_KEY"] != "CONFIG_VALUE" {
+		t.Errorf("Expected CONFIG_KEY to be CONFIG_VALUE, got %s", data["CONFIG_KEY"])
+	}
+}
+
+func Test_VaultClient_GivenCertAuthWithUnknownCertificate_ReturnsError(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+	trustedCertificate := writeTestTlsCertificate(t, "vault-client")
+	unknownCertificate := writeTestTlsCertificate(t, "unknown-client")
+	clientConfig, serverCertificate := createTestTlsVaultWithSecrets(t, &unknownCertificate, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	setupTestCertAuth(t, createTestTlsRootClient(t, clientConfig, serverCertificate, &unknownCertificate), "runner", trustedCertificate)
+
+	clientConfig.AuthMethod = "cert"
+	clientConfig.AuthToken = ""
+	clientConfig.CaCert = serverCertificate.certPem
+	clientConfig.ClientCert = unknownCertificate.certPath
+	clientConfig.ClientKey = unknownCertificate.keyPath
+
+	//Act
+	_, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err == nil {
+		t.Error("Expected the unknown certificate to be rejected")
+	}
+}
+
+func Test_GivenCertAuthWithoutClientCertificate_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:      "https://localhost:8200",
+		app.VaultEngine:       "test-engine",
+		app.VaultSecretPath:   "test-path",
+		app.Kubeconfig:        "test-kubeconfig",
+		app.Namespace:         "test-namespace",
+		app.ObjectNameToApply: "test-secret",
+		app.VaultAuthMethod:   "cert",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil || err.Error() != "Vault TLS client certificate and key are required for cert auth" {
+		t.Error("Expected missing client certificate error, got ", err)
+	}
+}
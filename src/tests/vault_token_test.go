@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	vaultclient "k8s-from-secrets-vault/vault"
+	"testing"
+	"time"
+)
+
+func Test_VaultClient_GivenLoginToken_RevokesTokenOnClose(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	roleId, secretId := setupTestAppRole(t, clientConfig, "1h", "2h")
+	tokensBefore := countTestVaultTokens(t, clientConfig)
+
+	appRoleConfig := clientConfig
+	appRoleConfig.AuthMethod = "approle"
+	appRoleConfig.AppRoleId = roleId
+	appRoleConfig.SecretId = secretId
+
+	//Act
+	client, err := vaultclient.NewClient(appRoleConfig, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokensLoggedIn := countTestVaultTokens(t, clientConfig)
+	client.Close()
+
+	//Assert
+	if tokensLoggedIn != tokensBefore+1 {
+		t.Errorf("Expected the login to create a token, got %d tokens from %d", tokensLoggedIn, tokensBefore)
+	}
+	if tokens := countTestVaultTokens(t, clientConfig); tokens != tokensBefore {
+		t.Errorf("Expected the login token to be revoked, got %d tokens instead of %d", tokens, tokensBefore)
+	}
+}
+
+func Test_VaultClient_GivenConfiguredToken_DoesNotRevokeTokenOnClose(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	//Act
+	client, err := vaultclient.NewClient(clientConfig, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	//Assert
+	_, err = vaultclient.LoadSecretData(clientConfig, log)
+	if err != nil {
+		t.Error("Expected the configured token to stay valid, got ", err)
+	}
+}
+
+func Test_VaultClient_GivenExpiringLoginToken_KeepsReadingByLoggingInAgain(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"CONFIG_KEY": "CONFIG_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	roleId, secretId := setupTestAppRole(t, clientConfig, "2s", "3s")
+
+	appRoleConfig := clientConfig
+	appRoleConfig.AuthMethod = "approle"
+	appRoleConfig.AppRoleId = roleId
+	appRoleConfig.SecretId = secretId
+	appRoleConfig.KvVersion = vaultclient.KvVersion2
+
+	client, err := vaultclient.NewClient(appRoleConfig, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	//Act
+	firstAccessor, err := client.TokenAccessor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.KeepTokenAlive(ctx)
+	waitForNewTokenAccessor(t, client, firstAccessor)
+	secret, err := client.LoadSecret()
+
+	//Assert
+	if err != nil {
+		t.Fatal("Expected the client to stay authenticated past the token max TTL, got ", err)
+	}
+	if secret.Data["CONFIG_KEY"] != "CONFIG_VALUE" {
+		t.Errorf("Expected CONFIG_KEY to be CONFIG_VALUE, got %s", secret.Data["CONFIG_KEY"])
+	}
+}
+
+func Test_VaultClient_GivenCloseDuringRenewal_StopsLoggingInAgain(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	// The fake Vault cannot renew tokens, so the renewal logs in again as soon as a token expires
+	fakeVault := createFakeLeaseVault(t, "database/creds/app")
+	fakeVault.setTokenTtl(2)
+
+	client, err := vaultclient.NewClient(vaultclient.VaultConfig{
+		Address:    fakeVault.server.URL,
+		AuthMethod: "approle",
+		AuthMount:  "approle",
+		AppRoleId:  "role-id",
+		SecretId:   "secret-id",
+		EngineName: "database",
+		SecretPath: "app",
+	}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	//Act
+	client.KeepTokenAlive(ctx)
+	_, err = client.ReadLeasedSecret("database/creds/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForFakeVaultLogins(t, fakeVault, 2)
+	client.Close()
+
+	//Assert
+	deadline := time.Now().Add(10 * time.Second)
+	for len(fakeVault.revokedTokenList()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	revoked := fakeVault.revokedTokenList()
+	if len(revoked) != 1 || revoked[0] != fmt.Sprintf("login-token-%d", fakeVault.loginCount()) {
+		t.Errorf("Expected only the login after Close to be revoked, got %v after %d logins", revoked, fakeVault.loginCount())
+	}
+}
+
+// waitForNewTokenAccessor waits until the client logged in again and uses a token other than the one with the given accessor
+func waitForNewTokenAccessor(t *testing.T, client *vaultclient.Client, accessor string) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		current, err := client.TokenAccessor()
+		if err == nil && current != accessor {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for the client to log in again")
+}
+
+// waitForFakeVaultLogins waits until the fake Vault served a number of AppRole logins
+func waitForFakeVaultLogins(t *testing.T, fakeVault *fakeLeaseVault, logins int) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if fakeVault.loginCount() >= logins {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d logins", logins)
+}
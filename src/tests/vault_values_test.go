@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"encoding/json"
+	"k8s-from-secrets-vault/app"
+	vaultclient "k8s-from-secrets-vault/vault"
+	"strings"
+	"testing"
+)
+
+func nestedTestSecret() map[string]interface{} {
+	return map[string]interface{}{
+		"name":     "service",
+		"enabled":  true,
+		"replicas": json.Number("1000000"),
+		"big":      json.Number("123456789012345"),
+		"ratio":    json.Number("0.000001"),
+		"hosts":    []interface{}{"a.example.com", "b.example.com"},
+		"database": map[string]interface{}{
+			"host": "db.example.com",
+			"port": json.Number("5432"),
+			"url":  "postgres://db?sslmode=require&timeout=5",
+			"pool": map[string]interface{}{"size": json.Number("10")},
+		},
+		"empty": map[string]interface{}{},
+	}
+}
+
+func Test_VaultClient_GivenNestedValues_RendersThemAsJson(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, nestedTestSecret())
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	//Act
+	secretData, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"name":     "service",
+		"enabled":  "true",
+		"replicas": "1000000",
+		"big":      "123456789012345",
+		"ratio":    "0.000001",
+		"hosts":    `["a.example.com","b.example.com"]`,
+		"database": `{"host":"db.example.com","pool":{"size":10},"port":5432,"url":"postgres://db?sslmode=require&timeout=5"}`,
+		"empty":    "{}",
+	}
+	if len(secretData) != len(expected) {
+		t.Errorf("Expected %d keys, got %d", len(expected), len(secretData))
+	}
+	for key, value := range expected {
+		if secretData[key] != value {
+			t.Errorf("Expected %s to be %s, got %s", key, value, secretData[key])
+		}
+	}
+}
+
+func Test_VaultClient_GivenFlattenMode_ExpandsNestedObjects(t *testing.T) {
+	testCases := map[string]struct {
+		flattenMode string
+		expected    map[string]string
+	}{
+		"dot": {
+			flattenMode: vaultclient.FlattenDot,
+			expected: map[string]string{
+				"database.host":      "db.example.com",
+				"database.port":      "5432",
+				"database.url":       "postgres://db?sslmode=require&timeout=5",
+				"database.pool.size": "10",
+			},
+		},
+		"underscore": {
+			flattenMode: vaultclient.FlattenUnderscore,
+			expected: map[string]string{
+				"database_host":      "db.example.com",
+				"database_port":      "5432",
+				"database_url":       "postgres://db?sslmode=require&timeout=5",
+				"database_pool_size": "10",
+			},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			//Arrange
+			log := setupLogger(t)
+
+			clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, nestedTestSecret())
+			defer destroyVaultHttpListener(t, vaultHttpListener)
+			clientConfig.FlattenMode = testCase.flattenMode
+
+			//Act
+			secretData, err := vaultclient.LoadSecretData(clientConfig, log)
+
+			//Assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range testCase.expected {
+				if secretData[key] != value {
+					t.Errorf("Expected %s to be %s, got %s", key, value, secretData[key])
+				}
+			}
+			if _, exists := secretData["database"]; exists {
+				t.Error("Expected the nested object to be replaced by its flattened keys")
+			}
+			if secretData["hosts"] != `["a.example.com","b.example.com"]` {
+				t.Errorf("Expected arrays to stay JSON, got %s", secretData["hosts"])
+			}
+			if secretData["empty"] != "{}" {
+				t.Errorf("Expected empty objects to stay JSON, got %s", secretData["empty"])
+			}
+			if len(secretData) != 11 {
+				t.Errorf("Expected 11 keys, got %d", len(secretData))
+			}
+		})
+	}
+}
+
+func Test_VaultClient_GivenFlattenedKeyCollision_ReturnsError(t *testing.T) {
+	//Arrange
+	log := setupLogger(t)
+
+	clientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{
+		"database.host": "primary",
+		"database":      map[string]interface{}{"host": "replica"},
+	})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+	clientConfig.FlattenMode = vaultclient.FlattenDot
+
+	//Act
+	_, err := vaultclient.LoadSecretData(clientConfig, log)
+
+	//Assert
+	if err == nil || !strings.Contains(err.Error(), "flattened key database.host collides") {
+		t.Error("Expected a flattened key collision error, got ", err)
+	}
+}
+
+func Test_GivenInvalidFlattenMode_ReturnsError(t *testing.T) {
+	//Arrange
+	commandArgs := map[string]string{
+		app.VaultAddress:      "http://",
+		app.VaultToken:        "test-token",
+		app.VaultEngine:       "test-engine",
+		app.VaultSecretPath:   "test-path",
+		app.VaultFlattenMode:  "slash",
+		app.Namespace:         "test-namespace",
+		app.Kubeconfig:        "test-kubeconfig",
+		app.ObjectNameToApply: "test-secret",
+		app.VaultAuthMethod:   "token",
+	}
+
+	//Act
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+
+	//Assert
+	if err == nil || err.Error() != "Vault flatten mode must be dot or underscore" {
+		t.Error("Expected invalid flatten mode error, got ", err)
+	}
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"context"
+	"k8s-from-secrets-vault/app"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Command_GivenWatch_AppliesOnlyWhenVaultContentChanges(t *testing.T) {
+	vaultClientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"CONFIG_KEY": "FIRST_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	command, fakeClient := setupTestCommand(t, vaultClientConfig, watchTestArgs)
+	stop := startTestWatch(t, command)
+
+	waitForSecretValue(t, fakeClient, "CONFIG_KEY", "FIRST_VALUE")
+	waitForWatchSyncs(t, fakeClient, 2)
+	if creates, patches := countSecretActions(fakeClient, "create"), countSecretActions(fakeClient, "patch"); creates != 1 || patches != 0 {
+		t.Errorf("Expected a single create while the content is unchanged, got %d creates and %d patches", creates, patches)
+	}
+
+	writeTestSecret(t, vaultClientConfig, map[string]interface{}{"CONFIG_KEY": "SECOND_VALUE"})
+	waitForSecretValue(t, fakeClient, "CONFIG_KEY", "SECOND_VALUE")
+	waitForWatchSyncs(t, fakeClient, 2)
+
+	stop()
+	if patches := countSecretActions(fakeClient, "patch"); patches != 1 {
+		t.Errorf("Expected one update after the content changed, got %d", patches)
+	}
+}
+
+func Test_Command_GivenWatch_RestoresDirectEditsOfTheCluster(t *testing.T) {
+	vaultClientConfig, vaultHttpListener := getClientConfigForNewTestVaultWithSecretsAndTokenAuth(t, map[string]interface{}{"CONFIG_KEY": "FIRST_VALUE"})
+	defer destroyVaultHttpListener(t, vaultHttpListener)
+
+	command, fakeClient := setupTestCommand(t, vaultClientConfig, watchTestArgs)
+	stop := startTestWatch(t, command)
+	defer stop()
+
+	waitForSecretValue(t, fakeClient, "CONFIG_KEY", "FIRST_VALUE")
+	secret := getTestSecret(t, fakeClient)
+	secret.StringData["CONFIG_KEY"] = "EDITED_VALUE"
+	_, err := fakeClient.CoreV1().Secrets("test-namespace").Update(context.TODO(), secret, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForSecretValue(t, fakeClient, "CONFIG_KEY", "FIRST_VALUE")
+}
+
+func Test_Command_GivenInvalidWatchInterval_ReturnsError(t *testing.T) {
+	parameters := getFakeKubernetesParameters(t)
+
+	commandArgs := map[string]string{
+		app.VaultAddress:      "http://localhost:8200",
+		app.VaultToken:        "token",
+		app.VaultEngine:       "secret",
+		app.VaultSecretPath:   "config",
+		app.Kubeconfig:        parameters.Base64Kubeconfig,
+		app.Namespace:         parameters.Namespace,
+		app.ObjectNameToApply: "test-secret",
+		app.VaultAuthMethod:   "token",
+		app.WatchInterval:     "soon",
+	}
+
+	_, err := app.SetupCommandWithKubernetesClient(commandArgs, nil)
+	if err == nil {
+		t.Error("Expected error for an invalid watch interval")
+	}
+}
+
+var watchTestArgs = map[string]string{app.WatchInterval: "20ms", app.WatchJitter: "5ms"}
+
+// startTestWatch runs the watch in the background, the returned function stops it and fails the test on a watch error
+func startTestWatch(t *testing.T, command *app.Command) func() {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		stopped <- command.Watch(ctx)
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			err := <-stopped
+			if err != nil {
+				t.Error("Expected watch to stop without error, got ", err)
+			}
+		})
+	}
+}
+
+// waitForWatchSyncs waits until the watch compared the secret with the live object a number of further times
+func waitForWatchSyncs(t *testing.T, fakeClient *fake.Clientset, syncs int) {
+	t.Helper()
+
+	expected := countSecretActions(fakeClient, "get") + syncs
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if countSecretActions(fakeClient, "get") >= expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d watch syncs", syncs)
+}
+
+func waitForSecretValue(t *testing.T, fakeClient *fake.Clientset, key string, value string) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		secret, err := fakeClient.CoreV1().Secrets("test-namespace").Get(context.TODO(), "test-secret", metav1.GetOptions{})
+		if err == nil && secret.StringData[key] == value {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s to be %s", key, value)
+}
+
+func countSecretActions(fakeClient *fake.Clientset, verb string) int {
+	count := 0
+	for _, action := range fakeClient.Actions() {
+		if action.GetResource().Resource == "secrets" && action.GetVerb() == verb {
+			count++
+		}
+	}
+	return count
+}
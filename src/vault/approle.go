@@ -0,0 +1,49 @@
+package vault_client
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+// unwrapSecretId exchanges the wrapping token for the AppRole secret id, after checking it wraps a secret id
+func unwrapSecretId(config VaultConfig, log *logrus.Logger) (string, error) {
+	client, err := newVaultApiClient(config, log)
+	if err != nil {
+		return "", err
+	}
+	client.ClearToken()
+
+	// The lookup leaves the wrapping token intact and checks what it wraps, only the unwrap below uses it up. A token
+	// that was already unwrapped fails here, which means someone else may hold the secret id
+	lookup, err := client.Logical().Write("sys/wrapping/lookup", map[string]interface{}{"token": config.WrappedSecretId})
+	if err != nil {
+		log.WithError(err).Error("The AppRole secret id wrapping token is invalid, expired or was already used")
+		return "", fmt.Errorf("secret id wrapping token is invalid, expired or was already used: %w", err)
+	}
+	if lookup == nil || lookup.Data == nil {
+		log.Error("The AppRole secret id wrapping token lookup returned no data")
+		return "", fmt.Errorf("secret id wrapping token lookup response is empty")
+	}
+	creationPath, _ := lookup.Data["creation_path"].(string)
+	if !strings.HasPrefix(creationPath, "auth/") || !strings.HasSuffix(creationPath, "/secret-id") {
+		log.WithField("creationPath", creationPath).Error("The wrapping token does not wrap an AppRole secret id")
+		return "", fmt.Errorf("wrapping token was created by %s, not by an approle secret id request", creationPath)
+	}
+
+	secret, err := client.Logical().Unwrap(config.WrappedSecretId)
+	if err != nil {
+		log.WithError(err).Error("The AppRole secret id wrapping token is invalid, expired or was already used")
+		return "", fmt.Errorf("secret id wrapping token is invalid, expired or was already used: %w", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("unwrapped secret id response is empty")
+	}
+	secretId, _ := secret.Data["secret_id"].(string)
+	if secretId == "" {
+		return "", fmt.Errorf("unwrapped response does not contain a secret id")
+	}
+
+	log.WithField("creationPath", creationPath).Info("Unwrapped the AppRole secret id")
+	return secretId, nil
+}
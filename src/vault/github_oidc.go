@@ -0,0 +1,56 @@
+package vault_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type githubOidcTokenResponse struct {
+	Value string `json:"value"`
+}
+
+// requestGithubOidcToken exchanges the Github Actions request token for a signed OIDC token (JWT)
+func requestGithubOidcToken(requestUrl string, requestToken string, audience string) (string, error) {
+	tokenUrl, err := url.Parse(requestUrl)
+	if err != nil {
+		return "", fmt.Errorf("invalid oidc request url: %w", err)
+	}
+
+	if audience != "" {
+		query := tokenUrl.Query()
+		query.Set("audience", audience)
+		tokenUrl.RawQuery = query.Encode()
+	}
+
+	request, err := http.NewRequest(http.MethodGet, tokenUrl.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", "bearer "+requestToken)
+	request.Header.Set("Accept", "application/json")
+
+	httpClient := http.Client{Timeout: 30 * time.Second}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to request oidc token: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to request oidc token: unexpected status %s", response.Status)
+	}
+
+	var tokenResponse githubOidcTokenResponse
+	err = json.NewDecoder(response.Body).Decode(&tokenResponse)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode oidc token response: %w", err)
+	}
+	if tokenResponse.Value == "" {
+		return "", fmt.Errorf("oidc token response is empty")
+	}
+
+	return tokenResponse.Value, nil
+}
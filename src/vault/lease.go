@@ -0,0 +1,70 @@
+package vault_client
+
+import (
+	"fmt"
+	"time"
+)
+
+// LeasedSecret is a dynamic secret together with the lease Vault created for it
+type LeasedSecret struct {
+	Data          map[string]string
+	LeaseId       string
+	LeaseDuration time.Duration
+	Renewable     bool
+	// TokenAccessor identifies the token that created the lease, the lease ends when that token does
+	TokenAccessor string
+	// TokenTtl is the time left on that token, zero when the token does not expire
+	TokenTtl time.Duration
+}
+
+// ReadLeasedSecret reads a lease based secret, like <database>/creds/<role>, creating new credentials on every call
+func (c *Client) ReadLeasedSecret(path string) (LeasedSecret, error) {
+	c.log.Infof("Reading leased secret from %s", path)
+	secret, err := c.api.Logical().Read(path)
+	if err != nil {
+		c.log.WithError(err).Error("Failed to read leased secret")
+		return LeasedSecret{}, err
+	}
+	if secret == nil || secret.Data == nil {
+		return LeasedSecret{}, fmt.Errorf("no secret returned by %s", path)
+	}
+
+	data, err := secretValues(secret.Data, c.config.FlattenMode)
+	if err != nil {
+		c.log.WithError(err).Error("Failed to read leased secret values")
+		if secret.LeaseID != "" {
+			_ = c.RevokeLease(secret.LeaseID)
+		}
+		return LeasedSecret{}, err
+	}
+
+	leased := LeasedSecret{
+		Data:          data,
+		LeaseId:       secret.LeaseID,
+		LeaseDuration: time.Duration(secret.LeaseDuration) * time.Second,
+		Renewable:     secret.Renewable,
+	}
+	if leased.LeaseId != "" {
+		c.mutex.Lock()
+		c.leased = true
+		c.mutex.Unlock()
+	}
+	info, err := c.lookupToken()
+	leased.TokenAccessor, leased.TokenTtl = info.accessor, info.ttl
+	if err != nil {
+		c.log.WithError(err).Warn("Failed to look up the Vault token owning the lease")
+	}
+	c.log.Infof("Read leased secret %s valid for %s", leased.LeaseId, leased.LeaseDuration)
+	return leased, nil
+}
+
+// RevokeLease revokes a lease so that the credentials it backs stop working
+func (c *Client) RevokeLease(leaseId string) error {
+	c.log.Infof("Revoking lease %s", leaseId)
+	err := c.api.Sys().Revoke(leaseId)
+	if err != nil {
+		c.log.WithError(err).WithField("lease", leaseId).Error("Failed to revoke lease")
+		return err
+	}
+	return nil
+}
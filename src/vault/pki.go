@@ -0,0 +1,76 @@
+package vault_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CertificateRequest holds the parameters sent to a PKI engine issue endpoint
+type CertificateRequest struct {
+	EngineName string
+	Role       string
+	CommonName string
+	AltNames   []string
+	Ttl        string
+}
+
+// IssuedCertificate is a PEM encoded certificate and private key issued by a PKI engine
+type IssuedCertificate struct {
+	Certificate  string
+	PrivateKey   string
+	IssuingCa    string
+	SerialNumber string
+	Expiration   time.Time
+}
+
+// IssueCertificate requests a new certificate from <engine>/issue/<role>
+func (c *Client) IssueCertificate(request CertificateRequest) (IssuedCertificate, error) {
+	path := request.EngineName + "/issue/" + request.Role
+	data := map[string]interface{}{
+		"common_name": request.CommonName,
+	}
+	if len(request.AltNames) > 0 {
+		data["alt_names"] = strings.Join(request.AltNames, ",")
+	}
+	if request.Ttl != "" {
+		data["ttl"] = request.Ttl
+	}
+
+	c.log.Infof("Issuing certificate for %s from %s", request.CommonName, path)
+	secret, err := c.api.Logical().Write(path, data)
+	if err != nil {
+		c.log.WithError(err).Error("Failed to issue certificate")
+		return IssuedCertificate{}, err
+	}
+	if secret == nil || secret.Data == nil {
+		return IssuedCertificate{}, fmt.Errorf("no certificate returned by %s", path)
+	}
+
+	var certificate IssuedCertificate
+	fields := []struct {
+		key   string
+		value *string
+	}{
+		{"certificate", &certificate.Certificate},
+		{"private_key", &certificate.PrivateKey},
+		{"issuing_ca", &certificate.IssuingCa},
+	}
+	for _, field := range fields {
+		pem, ok := secret.Data[field.key].(string)
+		if !ok || pem == "" {
+			return IssuedCertificate{}, fmt.Errorf("no %s returned by %s", field.key, path)
+		}
+		*field.value = pem
+	}
+	certificate.SerialNumber, _ = secret.Data["serial_number"].(string)
+	if expiration, ok := secret.Data["expiration"].(json.Number); ok {
+		if seconds, err := expiration.Int64(); err == nil {
+			certificate.Expiration = time.Unix(seconds, 0)
+		}
+	}
+
+	c.log.Infof("Issued certificate %s for %s", certificate.SerialNumber, request.CommonName)
+	return certificate, nil
+}
@@ -0,0 +1,151 @@
+package vault_client
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+// SecretSource identifies a secret path inside a secrets engine
+type SecretSource struct {
+	EngineName string
+	SecretPath string
+}
+
+const (
+	MergeLastWins  = "last-wins"
+	MergeFirstWins = "first-wins"
+	MergeFail      = "fail"
+)
+
+func (source SecretSource) String() string {
+	return source.EngineName + ":" + source.SecretPath
+}
+
+// ParseSecretSources reads a comma or newline separated list of paths, each optionally prefixed with "<engine>:"
+func ParseSecretSources(paths string, defaultEngine string) []SecretSource {
+	var sources []SecretSource
+
+	for _, entry := range strings.FieldsFunc(paths, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		source := SecretSource{EngineName: defaultEngine, SecretPath: entry}
+		if engine, path, found := strings.Cut(entry, ":"); found {
+			source = SecretSource{EngineName: strings.Trim(engine, "/"), SecretPath: path}
+		}
+		source.SecretPath = strings.Trim(source.SecretPath, "/")
+		sources = append(sources, source)
+	}
+
+	return sources
+}
+
+func (config VaultConfig) secretSources() []SecretSource {
+	if len(config.Sources) > 0 {
+		return config.Sources
+	}
+	return []SecretSource{{EngineName: config.EngineName, SecretPath: config.SecretPath}}
+}
+
+func (config VaultConfig) withSource(source SecretSource) VaultConfig {
+	config.EngineName = source.EngineName
+	config.SecretPath = source.SecretPath
+	config.Sources = nil
+	return config
+}
+
+func isMergeStrategy(strategy string) bool {
+	return strategy == "" || strategy == MergeLastWins || strategy == MergeFirstWins || strategy == MergeFail
+}
+
+// mergeSecretData copies the source data into the merged data following the configured conflict strategy
+func mergeSecretData(merged map[string]string, data map[string]string, strategy string, source SecretSource) error {
+	for key, value := range data {
+		if _, exists := merged[key]; exists {
+			switch strategy {
+			case MergeFail:
+				return fmt.Errorf("key %s from %s is already defined by a previous path", key, source)
+			case MergeFirstWins:
+				continue
+			}
+		}
+		merged[key] = value
+	}
+	return nil
+}
+
+// ListSecrets walks the folder of the source recursively, returning the leaf secret paths relative to it
+func (c *Client) ListSecrets(source SecretSource) ([]string, error) {
+	config := c.config.withSource(source)
+
+	var err error
+	config.KvVersion, err = resolveKvVersion(config, c.log, c.api)
+	if err != nil {
+		return nil, err
+	}
+
+	c.log.WithFields(logrus.Fields{
+		"address":    config.Address,
+		"namespace":  config.Namespace,
+		"secretPath": listPath(config, ""),
+	}).Info("Listing secrets")
+
+	return c.listFolder(config, "")
+}
+
+func (c *Client) listFolder(config VaultConfig, folder string) ([]string, error) {
+	secret, err := c.api.Logical().List(listPath(config, folder))
+	if err != nil {
+		c.log.WithError(err).Errorf("Failed to list vault path %s", listPath(config, folder))
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return []string{}, nil
+	}
+
+	keys, _ := secret.Data["keys"].([]interface{})
+
+	var paths []string
+	for _, key := range keys {
+		name, ok := key.(string)
+		if !ok {
+			continue
+		}
+
+		if strings.HasSuffix(name, "/") {
+			children, err := c.listFolder(config, folder+name)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, children...)
+			continue
+		}
+		paths = append(paths, folder+name)
+	}
+
+	return paths, nil
+}
+
+// listPath builds the LIST endpoint of a folder, which lives under metadata/ on KVv2 engines
+func listPath(config VaultConfig, folder string) string {
+	root := strings.Trim(config.SecretPath, "/")
+	if root != "" {
+		root += "/"
+	}
+	if config.KvVersion == KvVersion2 {
+		return fmt.Sprintf("%s/metadata/%s%s", config.EngineName, root, folder)
+	}
+	return fmt.Sprintf("%s/%s%s", config.EngineName, root, folder)
+}
+
+// JoinSecretPath appends a relative secret path to the folder of the source
+func (source SecretSource) JoinSecretPath(relativePath string) SecretSource {
+	folder := strings.Trim(source.SecretPath, "/")
+	if folder == "" {
+		return SecretSource{EngineName: source.EngineName, SecretPath: relativePath}
+	}
+	return SecretSource{EngineName: source.EngineName, SecretPath: folder + "/" + relativePath}
+}
@@ -0,0 +1,33 @@
+package vault_client
+
+import (
+	"github.com/hashicorp/vault/api"
+	"strings"
+)
+
+// usesTls tells whether any TLS option differs from the defaults of the Vault api client
+func (config VaultConfig) usesTls() bool {
+	return config.CaCert != "" || config.CaPath != "" || config.ClientCert != "" || config.ClientKey != "" ||
+		config.TlsServerName != "" || config.TlsSkipVerify
+}
+
+// tlsConfig maps the TLS options to the Vault api, CaCert holds either an inline PEM bundle or the path of one
+func (config VaultConfig) tlsConfig() *api.TLSConfig {
+	tlsConfig := &api.TLSConfig{
+		CAPath:        config.CaPath,
+		ClientCert:    config.ClientCert,
+		ClientKey:     config.ClientKey,
+		TLSServerName: config.TlsServerName,
+		Insecure:      config.TlsSkipVerify,
+	}
+	if isPem(config.CaCert) {
+		tlsConfig.CACertBytes = []byte(config.CaCert)
+	} else {
+		tlsConfig.CACert = config.CaCert
+	}
+	return tlsConfig
+}
+
+func isPem(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN")
+}
@@ -0,0 +1,174 @@
+package vault_client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault/api"
+	"time"
+)
+
+// tokenInfo holds what can be logged about the client token, the token itself is never included
+type tokenInfo struct {
+	accessor  string
+	ttl       time.Duration
+	renewable bool
+}
+
+func (c *Client) lookupToken() (tokenInfo, error) {
+	secret, err := c.api.Auth().Token().LookupSelf()
+	if err != nil {
+		return tokenInfo{}, err
+	}
+	if secret == nil || secret.Data == nil {
+		return tokenInfo{}, fmt.Errorf("token lookup returned no data")
+	}
+
+	info := tokenInfo{}
+	info.accessor, _ = secret.Data["accessor"].(string)
+	info.renewable, _ = secret.Data["renewable"].(bool)
+	if ttl, ok := secret.Data["ttl"].(json.Number); ok {
+		seconds, _ := ttl.Int64()
+		info.ttl = time.Duration(seconds) * time.Second
+	}
+	return info, nil
+}
+
+func (c *Client) logToken(message string) {
+	info, err := c.lookupToken()
+	if err != nil {
+		c.log.WithError(err).Warn("Failed to look up the Vault token")
+		return
+	}
+	c.log.WithField("accessor", info.accessor).
+		WithField("ttl", info.ttl.String()).
+		WithField("renewable", info.renewable).
+		Info(message)
+}
+
+// TokenAccessor returns the accessor of the current token, which identifies it without exposing it
+func (c *Client) TokenAccessor() (string, error) {
+	info, err := c.lookupToken()
+	return info.accessor, err
+}
+
+// Close revokes the token when it was issued by a login, tokens given in the configuration or owning leases are left alive
+func (c *Client) Close() {
+	if !c.loggedIn {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+
+	info, _ := c.lookupToken()
+	if c.leased {
+		c.log.WithField("accessor", info.accessor).Info("Leaving the Vault token to expire, revoking it would revoke the leased credentials")
+		return
+	}
+	err := c.api.Auth().Token().RevokeSelf("")
+	if err != nil {
+		c.log.WithError(err).WithField("accessor", info.accessor).Warn("Failed to revoke the Vault token")
+		return
+	}
+	c.log.WithField("accessor", info.accessor).Info("Revoked the Vault token")
+}
+
+// KeepTokenAlive renews the token in the background until the context is cancelled, logging in again once it can no longer be renewed
+func (c *Client) KeepTokenAlive(ctx context.Context) {
+	go func() {
+		for {
+			expired, err := c.watchToken(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				c.log.WithError(err).Warn("Vault token renewal failed")
+			}
+			if !expired {
+				return
+			}
+			if !c.loggedIn {
+				c.log.Error("Vault token can no longer be renewed and was not issued by a login, configure a longer lived token")
+				return
+			}
+
+			err = c.relogin()
+			if errors.Is(err, errClientClosed) {
+				return
+			}
+			if err != nil {
+				c.log.WithError(err).Error("Failed to log in to Vault again, retrying")
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(reloginRetryInterval):
+				}
+			}
+		}
+	}()
+}
+
+const reloginRetryInterval = 30 * time.Second
+
+// errClientClosed stops the renewal once Close ran
+var errClientClosed = errors.New("vault client is closed")
+
+// watchToken renews the token until renewal is no longer possible, returning whether it is about to expire
+func (c *Client) watchToken(ctx context.Context) (bool, error) {
+	info, err := c.lookupToken()
+	if err != nil {
+		return true, err
+	}
+	if info.ttl == 0 {
+		c.log.WithField("accessor", info.accessor).Info("Vault token does not expire, renewal is not needed")
+		return false, nil
+	}
+
+	watcher, err := c.api.NewLifetimeWatcher(&api.LifetimeWatcherInput{
+		Secret: &api.Secret{Auth: &api.SecretAuth{
+			ClientToken:   c.api.Token(),
+			Accessor:      info.accessor,
+			LeaseDuration: int(info.ttl.Seconds()),
+			Renewable:     info.renewable,
+		}},
+	})
+	if err != nil {
+		return true, err
+	}
+	go watcher.Start()
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case err := <-watcher.DoneCh():
+			return true, err
+		case renewal := <-watcher.RenewCh():
+			c.log.WithField("accessor", info.accessor).
+				WithField("ttl", (time.Duration(renewal.Secret.Auth.LeaseDuration) * time.Second).String()).
+				Info("Renewed the Vault token")
+		}
+	}
+}
+
+// relogin authenticates again with the configured method and swaps the token of the client, the old token is left to expire with its leases
+func (c *Client) relogin() error {
+	client, err := newAuthenticatedVaultApiClient(c.config, c.log)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		_ = client.Auth().Token().RevokeSelf("")
+		return errClientClosed
+	}
+	c.api.SetToken(client.Token())
+	c.logToken("Logged in to Vault again")
+	return nil
+}
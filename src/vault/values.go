@@ -0,0 +1,89 @@
+package vault_client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	FlattenDot        = "dot"
+	FlattenUnderscore = "underscore"
+)
+
+func isFlattenMode(mode string) bool {
+	return mode == "" || mode == FlattenDot || mode == FlattenUnderscore
+}
+
+func flattenSeparator(mode string) string {
+	switch mode {
+	case FlattenDot:
+		return "."
+	case FlattenUnderscore:
+		return "_"
+	}
+	return ""
+}
+
+// secretValues renders the values of a Vault response as strings, expanding nested objects into separate keys when a
+// flatten mode is set
+func secretValues(values map[string]interface{}, flattenMode string) (map[string]string, error) {
+	secretData := make(map[string]string, len(values))
+	for key, value := range values {
+		err := addSecretValue(secretData, key, value, flattenSeparator(flattenMode))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return secretData, nil
+}
+
+func addSecretValue(secretData map[string]string, key string, value interface{}, separator string) error {
+	if object, ok := value.(map[string]interface{}); ok && separator != "" && len(object) > 0 {
+		for nestedKey, nestedValue := range object {
+			err := addSecretValue(secretData, key+separator+nestedKey, nestedValue, separator)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, exists := secretData[key]; exists {
+		return fmt.Errorf("flattened key %s collides with another key of the secret", key)
+	}
+	rendered, err := renderValue(value)
+	if err != nil {
+		return fmt.Errorf("failed to render the value of key %s: %w", key, err)
+	}
+	secretData[key] = rendered
+	return nil
+}
+
+// renderValue keeps strings and numbers exactly as Vault returned them and serializes objects and arrays as JSON
+func renderValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case map[string]interface{}, []interface{}:
+		var buffer bytes.Buffer
+		encoder := json.NewEncoder(&buffer)
+		// Values like URLs are kept readable, a JSON consumer decodes them the same either way
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(value); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(buffer.String(), "\n"), nil
+	}
+	return fmt.Sprintf("%v", value), nil
+}
@@ -1,23 +1,67 @@
 package vault_client
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"github.com/hashicorp/vault/api"
 	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 type VaultConfig struct {
-	Address     string
-	AuthToken   string
-	Namespace   string
-	EngineName  string
-	SecretPath  string
-	AuthMethod  string
+	Address    string
+	AuthToken  string
+	Namespace  string
+	EngineName string
+	SecretPath string
+	KvVersion  string
+	Version    int
+	AuthMethod string
+
+	// Sources lists the paths merged into a single secret, EngineName and SecretPath are used when empty
+	Sources       []SecretSource
+	MergeStrategy string
+	// FlattenMode expands nested objects into dotted or underscored keys, they are kept as JSON when empty
+	FlattenMode string
+
+	AuthMount   string
+	AuthRole    string
 	GithubToken string
 	AppRoleId   string
 	SecretId    string
+	Username    string
+	Password    string
+
+	// WrappedSecretId is a response wrapping token, unwrapped into SecretId before logging in
+	WrappedSecretId string
+
+	JwtAudience      string
+	OidcRequestUrl   string
+	OidcRequestToken string
+
+	KubernetesTokenPath string
+
+	CaCert        string
+	CaPath        string
+	ClientCert    string
+	ClientKey     string
+	TlsServerName string
+	TlsSkipVerify bool
 }
 
+const (
+	KvVersion1 = "1"
+	KvVersion2 = "2"
+)
+
+const DefaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
 func CheckVaultConfigRequiredFields(config VaultConfig) error {
 	if config.Address == "" {
 		return fmt.Errorf("address is required")
@@ -28,83 +72,284 @@ func CheckVaultConfigRequiredFields(config VaultConfig) error {
 	if config.AuthMethod == "github" && config.GithubToken == "" {
 		return fmt.Errorf("githubToken is required")
 	}
-	if config.EngineName == "" {
+	if (config.AuthMethod == "jwt" || config.AuthMethod == "kubernetes") && config.AuthRole == "" {
+		return fmt.Errorf("authRole is required")
+	}
+	if config.AuthMethod == "jwt" && (config.OidcRequestUrl == "" || config.OidcRequestToken == "") {
+		return fmt.Errorf("oidcRequestUrl and oidcRequestToken are required")
+	}
+	if (config.AuthMethod == "userpass" || config.AuthMethod == "ldap") && (config.Username == "" || config.Password == "") {
+		return fmt.Errorf("username and password are required")
+	}
+	if !IsAuthMount(config.AuthMount) {
+		return fmt.Errorf("authMount must be a path of letters, digits, dashes, underscores and dots")
+	}
+	if config.AuthMethod == "cert" && config.ClientCert == "" {
+		return fmt.Errorf("clientCert and clientKey are required")
+	}
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		return fmt.Errorf("clientCert and clientKey must be set together")
+	}
+	if config.EngineName == "" && len(config.Sources) == 0 {
 		return fmt.Errorf("engineName is required")
 	}
+	for _, source := range config.Sources {
+		if source.EngineName == "" || source.SecretPath == "" {
+			return fmt.Errorf("engineName and secretPath are required for every source")
+		}
+	}
+	if len(config.Sources) > 1 && config.Version > 0 {
+		return fmt.Errorf("version can only be pinned for a single source")
+	}
+	if !isMergeStrategy(config.MergeStrategy) {
+		return fmt.Errorf("mergeStrategy must be %s, %s or %s", MergeLastWins, MergeFirstWins, MergeFail)
+	}
+	if !isFlattenMode(config.FlattenMode) {
+		return fmt.Errorf("flattenMode must be %s or %s", FlattenDot, FlattenUnderscore)
+	}
+	if config.KvVersion != "" && config.KvVersion != KvVersion1 && config.KvVersion != KvVersion2 {
+		return fmt.Errorf("kvVersion must be %s or %s", KvVersion1, KvVersion2)
+	}
+	if config.Version < 0 {
+		return fmt.Errorf("version must be a positive number")
+	}
 	return nil
 }
 
+// LoadedSecret holds the values read from a secret path and the KVv2 version they belong to
+type LoadedSecret struct {
+	Data    map[string]string
+	Version int
+}
+
 func LoadSecretData(config VaultConfig, log *logrus.Logger) (map[string]string, error) {
-	log.WithFields(logrus.Fields{
-		"address":    config.Address,
-		"namespace":  config.Namespace,
-		"secretPath": GetSecretPath(config),
-	}).Info("Loading secret data")
+	secret, err := LoadSecret(config, log)
+	if err != nil {
+		return nil, err
+	}
+	return secret.Data, nil
+}
 
+func LoadSecret(config VaultConfig, log *logrus.Logger) (LoadedSecret, error) {
+	client, err := NewClient(config, log)
+	if err != nil {
+		return LoadedSecret{}, err
+	}
+	defer client.Close()
+	return client.LoadSecret()
+}
+
+// Client is an authenticated Vault client that can be reused for several reads
+type Client struct {
+	api    *api.Client
+	config VaultConfig
+	log    *logrus.Logger
+	// loggedIn is set when the token was issued by a login, so the client owns it
+	loggedIn bool
+	// mutex guards leased, closed and the token swap of the renewal goroutine
+	mutex sync.Mutex
+	// leased is set once the token created a lease, Vault revokes the lease together with the token
+	leased bool
+	// closed is set by Close, a later login revokes its own token as nothing uses it anymore
+	closed bool
+}
+
+func NewClient(config VaultConfig, log *logrus.Logger) (*Client, error) {
 	err := CheckVaultConfigRequiredFields(config)
 	if err != nil {
 		return nil, err
 	}
 
+	// The secret id is kept unwrapped in the client config, a wrapping token could not be used to log in again
+	if config.AuthMethod == "approle" && config.WrappedSecretId != "" {
+		config.SecretId, err = unwrapSecretId(config, log)
+		if err != nil {
+			return nil, err
+		}
+		config.WrappedSecretId = ""
+	}
+
 	client, err := newAuthenticatedVaultApiClient(config, log)
 	if err != nil {
 		return nil, err
 	}
 
-	secret, err := client.Logical().Read(GetSecretPath(config))
+	vaultClient := &Client{api: client, config: config, log: log, loggedIn: config.AuthMethod != "" && config.AuthMethod != "token"}
+	vaultClient.logToken("Authenticated to Vault")
+	return vaultClient, nil
+}
+
+// LoadSecret reads the sources of the client configuration
+func (c *Client) LoadSecret() (LoadedSecret, error) {
+	return c.LoadSources(c.config.secretSources())
+}
+
+// LoadSources reads the given sources, merging them in order when there is more than one
+func (c *Client) LoadSources(sources []SecretSource) (LoadedSecret, error) {
+	if len(sources) == 1 {
+		return c.LoadSecretAt(sources[0])
+	}
+
+	merged := LoadedSecret{Data: map[string]string{}}
+	for _, source := range sources {
+		secret, err := c.LoadSecretAt(source)
+		if err != nil {
+			return LoadedSecret{}, err
+		}
+
+		err = mergeSecretData(merged.Data, secret.Data, c.config.MergeStrategy, source)
+		if err != nil {
+			c.log.WithError(err).Error("Failed to merge secret data")
+			return LoadedSecret{}, err
+		}
+	}
+
+	return merged, nil
+}
+
+func (c *Client) LoadSecretAt(source SecretSource) (LoadedSecret, error) {
+	return readSecret(c.api, c.config.withSource(source), c.log)
+}
+
+func readSecret(client *api.Client, config VaultConfig, log *logrus.Logger) (LoadedSecret, error) {
+	log.WithFields(logrus.Fields{
+		"address":    config.Address,
+		"namespace":  config.Namespace,
+		"secretPath": GetSecretPath(config),
+	}).Info("Loading secret data")
+
+	var err error
+	config.KvVersion, err = resolveKvVersion(config, log, client)
+	if err != nil {
+		return LoadedSecret{}, err
+	}
+
+	if config.Version > 0 && config.KvVersion != KvVersion2 {
+		log.Error(nil, "Secret versions are only supported by KVv2 engines")
+		return LoadedSecret{}, fmt.Errorf("secret versions are only supported by kv version 2 engines")
+	}
+
+	secret, err := client.Logical().ReadWithData(GetSecretPath(config), secretVersionQuery(config))
 	if err != nil {
 		log.Error(err, "Failed to read vault engine path %s", GetSecretPath(config))
-		return nil, err
+		return LoadedSecret{}, err
+	}
+
+	if secret == nil && config.Version > 0 {
+		log.Errorf("Secret version %d does not exist", config.Version)
+		return LoadedSecret{}, fmt.Errorf("secret version %d does not exist", config.Version)
 	}
 
 	if secret == nil {
 		if !vaultEngineExists(config, log, client) {
 			log.Error(nil, "Vault engine does not exist")
-			return nil, fmt.Errorf("vault engine does not exist")
+			return LoadedSecret{}, fmt.Errorf("vault engine does not exist")
 		}
 		log.Warning("Secret engine path is empty")
-		return map[string]string{}, nil
+		return LoadedSecret{Data: map[string]string{}}, nil
 	}
 
 	if secret.Data == nil {
 		log.Error(nil, "Failed to parse Vault secret data")
-		return nil, fmt.Errorf("failed to parse Vault secret data")
+		return LoadedSecret{}, fmt.Errorf("failed to parse Vault secret data")
 	}
 
-	secretData := make(map[string]string)
+	values := secret.Data
+	version := 0
 
-	// If secret.Data has key named "data" then it is a KVv2 secret
-	if _, ok := secret.Data["data"]; ok {
-		for k, v := range secret.Data["data"].(map[string]interface{}) {
-			var value = ""
-			if v != nil {
-				value = fmt.Sprintf("%v", v)
-			}
-			secretData[k] = value
-		}
-	} else {
-		for k, v := range secret.Data {
-			var value = ""
-			if v != nil {
-				value = fmt.Sprintf("%v", v)
-			}
-			secretData[k] = value
+	// KVv2 wraps the secret values in a "data" field next to the version metadata
+	if config.KvVersion == KvVersion2 {
+		values = map[string]interface{}{}
+		if data, ok := secret.Data["data"].(map[string]interface{}); ok {
+			values = data
+		} else if config.Version > 0 {
+			log.Errorf("Secret version %d was deleted or destroyed", config.Version)
+			return LoadedSecret{}, fmt.Errorf("secret version %d was deleted or destroyed", config.Version)
 		}
+		version = secretMetadataVersion(secret)
+	}
+
+	secretData, err := secretValues(values, config.FlattenMode)
+	if err != nil {
+		log.WithError(err).Error("Failed to read Vault secret values")
+		return LoadedSecret{}, err
 	}
 
 	log.WithFields(logrus.Fields{
 		"address":    config.Address,
 		"namespace":  config.Namespace,
 		"secretPath": GetSecretPath(config),
+		"version":    version,
 	}).Info("Loaded secret data")
 
-	return secretData, nil
+	return LoadedSecret{Data: secretData, Version: version}, nil
 }
 
 func GetSecretPath(config VaultConfig) string {
+	if config.KvVersion == KvVersion1 {
+		return fmt.Sprintf("%s/%s", config.EngineName, config.SecretPath)
+	}
 	return fmt.Sprintf("%s/data/%s", config.EngineName, config.SecretPath)
 }
 
+func secretVersionQuery(config VaultConfig) map[string][]string {
+	if config.Version == 0 {
+		return nil
+	}
+	return map[string][]string{"version": {strconv.Itoa(config.Version)}}
+}
+
+func secretMetadataVersion(secret *api.Secret) int {
+	metadata, ok := secret.Data["metadata"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	version, ok := metadata["version"].(json.Number)
+	if !ok {
+		return 0
+	}
+	number, err := version.Int64()
+	if err != nil {
+		return 0
+	}
+	return int(number)
+}
+
+// resolveKvVersion returns the configured KV version, or inspects the engine mount options to detect it
+func resolveKvVersion(config VaultConfig, log *logrus.Logger, client *api.Client) (string, error) {
+	if config.KvVersion != "" {
+		return config.KvVersion, nil
+	}
+
+	// The UI mounts endpoint is readable by any token with access to the engine, unlike sys/mounts
+	mount, err := client.Logical().Read("sys/internal/ui/mounts/" + config.EngineName)
+	if err == nil && mount != nil && mount.Data != nil {
+		options, _ := mount.Data["options"].(map[string]interface{})
+		version, _ := options["version"].(string)
+		return kvVersionFromOptions(version), nil
+	}
+
+	mounts, listErr := client.Sys().ListMounts()
+	if listErr != nil {
+		log.WithError(listErr).Error("Failed to detect Vault KV engine version, set it explicitly for restricted tokens")
+		return "", fmt.Errorf("failed to detect kv engine version: %w", listErr)
+	}
+	engineMount, ok := mounts[config.EngineName+"/"]
+	if !ok {
+		log.Error(nil, "Vault engine does not exist")
+		return "", fmt.Errorf("vault engine does not exist")
+	}
+
+	return kvVersionFromOptions(engineMount.Options["version"]), nil
+}
+
+func kvVersionFromOptions(version string) string {
+	if version == KvVersion2 {
+		return KvVersion2
+	}
+	return KvVersion1
+}
+
 func vaultEngineExists(config VaultConfig, log *logrus.Logger, client *api.Client) bool {
 	mounts, err := client.Sys().ListMounts()
 
@@ -120,31 +365,53 @@ func vaultEngineExists(config VaultConfig, log *logrus.Logger, client *api.Clien
 }
 
 func newAuthenticatedVaultApiClient(config VaultConfig, log *logrus.Logger) (*api.Client, error) {
-	client, err := api.NewClient(&api.Config{
-		Address: config.Address,
-	})
-
+	client, err := newVaultApiClient(config, log)
 	if err != nil {
-		log.WithError(err).Error("Failed to create vault client")
 		return nil, err
 	}
 
-	client.SetNamespace(config.Namespace)
-
 	if config.AuthMethod == "approle" {
-		client, err = authWithAppRole(config.AppRoleId, config.SecretId, client)
+		client, err = authWithAppRole(config, client)
 		if err != nil {
 			log.WithError(err).Error("Failed to authenticate with AppRole")
 			return nil, err
 		}
 	}
 	if config.AuthMethod == "github" {
-		client, err = authWithGithub(config.GithubToken, client)
+		client, err = authWithGithub(config, client)
 		if err != nil {
 			log.WithError(err).Error("Failed to authenticate with Github")
 			return nil, err
 		}
 	}
+	if config.AuthMethod == "jwt" {
+		client, err = authWithJwt(config, client)
+		if err != nil {
+			log.WithError(err).Error("Failed to authenticate with JWT")
+			return nil, err
+		}
+	}
+	if config.AuthMethod == "kubernetes" {
+		client, err = authWithKubernetes(config, client)
+		if err != nil {
+			log.WithError(err).Error("Failed to authenticate with Kubernetes service account")
+			return nil, err
+		}
+	}
+	if config.AuthMethod == "userpass" || config.AuthMethod == "ldap" {
+		client, err = authWithPassword(config, client)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to authenticate with %s", config.AuthMethod)
+			return nil, err
+		}
+	}
+	if config.AuthMethod == "cert" {
+		client, err = authWithCert(config, client)
+		if err != nil {
+			log.WithError(err).Error("Failed to authenticate with TLS certificate")
+			return nil, err
+		}
+	}
 	if config.AuthMethod == "token" {
 		client, err = authWithToken(config.AuthToken, client)
 		if err != nil {
@@ -156,39 +423,127 @@ func newAuthenticatedVaultApiClient(config VaultConfig, log *logrus.Logger) (*ap
 	return client, nil
 }
 
-func authWithAppRole(roleId string, secretId string, client *api.Client) (*api.Client, error) {
-	secret, err := client.Logical().Write("/auth/approle/login", map[string]interface{}{
-		"role_id":   roleId,
-		"secret_id": secretId,
+func authWithAppRole(config VaultConfig, client *api.Client) (*api.Client, error) {
+	return login(client, authLoginPath(config.AuthMount, "approle"), map[string]interface{}{
+		"role_id":   config.AppRoleId,
+		"secret_id": config.SecretId,
 	})
+}
 
+func authWithToken(token string, client *api.Client) (*api.Client, error) {
+	client.SetToken(token)
+	return client, nil
+}
+
+func authWithGithub(config VaultConfig, client *api.Client) (*api.Client, error) {
+	return login(client, authLoginPath(config.AuthMount, "github"), map[string]interface{}{
+		"token": config.GithubToken,
+	})
+}
+
+func authWithJwt(config VaultConfig, client *api.Client) (*api.Client, error) {
+	jwt, err := requestGithubOidcToken(config.OidcRequestUrl, config.OidcRequestToken, config.JwtAudience)
 	if err != nil {
 		return client, err
 	}
-	if secret == nil {
-		return client, nil
+
+	return login(client, authLoginPath(config.AuthMount, "jwt"), map[string]interface{}{
+		"role": config.AuthRole,
+		"jwt":  jwt,
+	})
+}
+
+func authWithKubernetes(config VaultConfig, client *api.Client) (*api.Client, error) {
+	tokenPath := config.KubernetesTokenPath
+	if tokenPath == "" {
+		tokenPath = DefaultKubernetesTokenPath
 	}
-	if secret.Auth == nil {
-		return client, fmt.Errorf("secret.Auth is nil")
+
+	jwt, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return client, fmt.Errorf("failed to read service account token: %w", err)
 	}
-	if secret.Auth.ClientToken == "" {
-		return client, fmt.Errorf("secret.Auth.ClientToken is empty")
+
+	return login(client, authLoginPath(config.AuthMount, "kubernetes"), map[string]interface{}{
+		"role": config.AuthRole,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+}
+
+// newVaultApiClient creates an unauthenticated client for the configured address, TLS options and namespace
+func newVaultApiClient(config VaultConfig, log *logrus.Logger) (*api.Client, error) {
+	// Only the HTTP client of the defaults is kept, TLS options must be set on its transport before creating the client
+	apiConfig := &api.Config{
+		Address:    config.Address,
+		HttpClient: api.DefaultConfig().HttpClient,
 	}
 
-	client.SetToken(secret.Auth.ClientToken)
+	// The defaults already hold the TLS settings of the VAULT_* environment variables of the Vault CLI. These are
+	// only used when no TLS input is configured, otherwise the configured inputs replace them as a whole
+	if config.usesTls() {
+		apiConfig.HttpClient.Transport.(*http.Transport).TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		err := apiConfig.ConfigureTLS(config.tlsConfig())
+		if err != nil {
+			log.WithError(err).Error("Failed to configure vault TLS")
+			return nil, err
+		}
+	}
 
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		log.WithError(err).Error("Failed to create vault client")
+		return nil, err
+	}
+
+	client.SetNamespace(config.Namespace)
 	return client, nil
 }
 
-func authWithToken(token string, client *api.Client) (*api.Client, error) {
-	client.SetToken(token)
-	return client, nil
+// authWithCert logs in with the TLS client certificate of the connection, the role name is optional
+func authWithCert(config VaultConfig, client *api.Client) (*api.Client, error) {
+	data := map[string]interface{}{}
+	if config.AuthRole != "" {
+		data["name"] = config.AuthRole
+	}
+
+	return login(client, authLoginPath(config.AuthMount, "cert"), data)
 }
 
-func authWithGithub(githubToken string, client *api.Client) (*api.Client, error) {
-	secret, err := client.Logical().Write("/auth/github/login", map[string]interface{}{
-		"token": githubToken,
+// authWithPassword logs in with a username and password, for the userpass and ldap auth methods alike
+func authWithPassword(config VaultConfig, client *api.Client) (*api.Client, error) {
+	path := authLoginPath(config.AuthMount, config.AuthMethod) + "/" + url.PathEscape(config.Username)
+	return login(client, path, map[string]interface{}{
+		"password": config.Password,
 	})
+}
+
+// authLoginPath builds the login endpoint of an auth method, falling back to its default mount
+func authLoginPath(mount string, defaultMount string) string {
+	mount = strings.Trim(mount, "/")
+	if mount == "" {
+		mount = defaultMount
+	}
+	return fmt.Sprintf("/auth/%s/login", mount)
+}
+
+// IsAuthMount tells whether the mount is a valid auth mount path, like github or teams/team-a/approle
+func IsAuthMount(mount string) bool {
+	mount = strings.Trim(mount, "/")
+	if mount == "" {
+		return true
+	}
+	for _, segment := range strings.Split(mount, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.Trim(segment, authMountCharacters) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+const authMountCharacters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_."
+
+func login(client *api.Client, path string, data map[string]interface{}) (*api.Client, error) {
+	secret, err := client.Logical().Write(path, data)
 
 	if err != nil {
 		return client, err